@@ -1,7 +1,11 @@
 package activator
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	guuid "github.com/google/uuid"
 
@@ -11,16 +15,58 @@ import (
 )
 
 type Activator struct {
-	driver *database.Driver
+	driver  *database.Driver
+	bundles *bundleCache
+}
+
+// Returned when asked to activate a hash that was rolled back before
+type BadHashError struct {
+	ID worker.ComponentID
+}
+
+func (e *BadHashError) Error() string {
+	return fmt.Sprintf("%v was rolled back before, refusing to deploy it again", e.ID)
 }
 
 func CreateActivator(driver *database.Driver) *Activator {
 	return &Activator{
-		driver: driver,
+		driver:  driver,
+		bundles: newBundleCache(),
+	}
+}
+
+// How long checking that a hash is in its repo can take
+const verifyHashTimeout = 5 * time.Minute
+
+// Whether activating compID would only copy a bundle that was already built for another replica
+func (a *Activator) IsBuilt(compID worker.ComponentID) bool {
+	return a.bundles.contains(compID)
+}
+
+// Activate the component on the worker, and record it in the deployment history.
+// Cancelling ctx stops the activation wherever it is, and makes it return ctx's error.
+func (a *Activator) Activate(
+	ctx context.Context,
+	compID worker.ComponentID,
+	worker *worker.V9Worker,
+	color string,
+	reason string) (string, error) {
+	record := database.NewDeploymentRecord(compID, worker.URL, database.ActivateAction, reason)
+	hash, err := a.activate(ctx, compID, worker, color, &record)
+	if err != nil && ctx.Err() != nil {
+		// Whatever failed, it failed because we stopped it
+		err = ctx.Err()
 	}
+	a.finishRecord(&record, err)
+	return hash, err
 }
 
-func (a *Activator) Activate(compID worker.ComponentID, worker *worker.V9Worker) (string, error) {
+func (a *Activator) activate(
+	ctx context.Context,
+	compID worker.ComponentID,
+	worker *worker.V9Worker,
+	color string,
+	record *database.DeploymentRecord) (string, error) {
 	// Setup the DB deploying entry
 	err := a.driver.EnterDeploymentEntry(compID)
 	if err != nil {
@@ -34,46 +80,129 @@ func (a *Activator) Activate(compID worker.ComponentID, worker *worker.V9Worker)
 		}
 	}()
 
-	// Get random tar name
-	tarName := guuid.New().String()
-	//Checkout Head and Clone repo update hash if needed
-	cloneResult, err := cloneAndSetHash(compID)
-	if err != nil {
-		log.Error.Println("Error checking out head and cloning", err)
-		return "", err
+	// Other replicas of the same hash only need the bundle copied over
+	b, built := a.bundles.acquire(compID)
+	if built {
+		err = a.checkNotBad(compID)
+	} else {
+		b, err = a.build(ctx, compID, record)
 	}
-	defer os.RemoveAll(cloneResult.path)
-	tarNameExt, err := buildComponentBundle(tarName, cloneResult.path)
 	if err != nil {
-		log.Error.Println("Error building component bundle", err)
 		return "", err
 	}
-
-	defer os.Remove("./" + tarNameExt)
+	defer a.bundles.release(b)
+	compID.Hash = record.ID.Hash
 
 	// Send .tar to worker
 	log.Info.Println("SCP tar to worker...")
-	source := "./" + tarNameExt
+	phaseStart := time.Now()
+	tarNameExt := filepath.Base(b.path)
+	source := b.path
 	destination := "/home/ubuntu/" + tarNameExt
-	err = scpToWorker(worker.URL, source, destination, tarNameExt)
+	err = scpToWorker(ctx, worker.URL, source, destination, tarNameExt)
 	if err != nil {
 		log.Error.Println("Error copying to worker", err)
 		return "", err
 	}
-
-	// Ensure hash is consistent
-	compID.Hash = cloneResult.hash
+	record.EndPhase(database.TransferPhase, phaseStart)
 
 	// Activate Component
-	err = worker.Activate(compID, destination)
+	phaseStart = time.Now()
+	err = worker.Activate(compID, destination, color)
 	if err != nil {
 		log.Error.Println("Error activating worker", err)
 		return "", err
 	}
+	record.EndPhase(database.ActivatePhase, phaseStart)
+
+	return compID.Hash, nil
+}
+
+// Clone and build compID, resolving HEAD in the record. Returns the acquired bundle.
+func (a *Activator) build(
+	ctx context.Context,
+	compID worker.ComponentID,
+	record *database.DeploymentRecord) (*bundle, error) {
+	// Get random tar name
+	tarName := guuid.New().String()
+	//Checkout Head and Clone repo update hash if needed
+	phaseStart := time.Now()
+	branch, err := a.driver.FindTrackedBranch(compID)
+	if err != nil {
+		log.Error.Println("Error finding tracked branch", err)
+		return nil, err
+	}
+	cloneResult, err := cloneAndSetHash(ctx, compID, branch)
+	if err != nil {
+		log.Error.Println("Error checking out head and cloning", err)
+		return nil, err
+	}
+	defer os.RemoveAll(cloneResult.path)
+	record.ID.Hash = cloneResult.hash
+	record.EndPhase(database.ClonePhase, phaseStart)
+
+	// Don't redeploy something we already rolled back (this matters when HEAD resolves to a bad hash)
+	compID.Hash = cloneResult.hash
+	err = a.checkNotBad(compID)
+	if err != nil {
+		return nil, err
+	}
+
+	// HEAD may turn out to be a hash another replica already built
+	if b, built := a.bundles.acquire(compID); built {
+		return b, nil
+	}
+
+	// The build counts against the user's builds per hour whether it works or not
+	phaseStart = time.Now()
+	tarNameExt, err := buildComponentBundle(ctx, tarName, cloneResult.path)
+	record.EndPhase(database.BuildPhase, phaseStart)
+	if err != nil {
+		log.Error.Println("Error building component bundle", err)
+		return nil, err
+	}
+
+	return a.bundles.add(compID, "./"+tarNameExt), nil
+}
+
+// Check that the hash is in the component's repo (by cloning it), so it can be built later.
+// Returns an error wrapping ErrUnknownRevision if it isn't.
+func (a *Activator) VerifyHash(compID worker.ComponentID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyHashTimeout)
+	defer cancel()
+
+	cloneResult, err := cloneAndSetHash(ctx, compID, "")
+	if err != nil {
+		return err
+	}
+	os.RemoveAll(cloneResult.path)
+	return nil
+}
 
-	return cloneResult.hash, nil
+func (a *Activator) checkNotBad(compID worker.ComponentID) error {
+	isBad, err := a.driver.IsBadHash(compID)
+	if err != nil {
+		log.Error.Println("Error checking for bad hash", err)
+		return err
+	}
+	if isBad {
+		return &BadHashError{ID: compID}
+	}
+	return nil
 }
 
-func (a *Activator) Deactivate(compID worker.ComponentID, worker *worker.V9Worker) error {
-	return worker.Deactivate(compID)
+// Deactivate the component on the worker, and record it in the deployment history
+func (a *Activator) Deactivate(compID worker.ComponentID, worker *worker.V9Worker, reason string) error {
+	record := database.NewDeploymentRecord(compID, worker.URL, database.DeactivateAction, reason)
+	err := worker.Deactivate(compID)
+	a.finishRecord(&record, err)
+	return err
+}
+
+func (a *Activator) finishRecord(record *database.DeploymentRecord, err error) {
+	record.Finish(err)
+	insertErr := a.driver.InsertDeploymentRecord(*record)
+	if insertErr != nil {
+		log.Error.Println("Error recording deployment history:", insertErr)
+	}
 }
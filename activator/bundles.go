@@ -0,0 +1,104 @@
+package activator
+
+import (
+	"os"
+	"sync"
+	"time"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// How long a built bundle is kept around for more replicas of the same hash
+const bundleRetention = 30 * time.Minute
+
+// A built and zipped image of one hash of a component
+type bundle struct {
+	path    string
+	builtAt time.Time
+	// How many activations are still copying it, so it isn't removed out from under them
+	users   int
+	evicted bool
+}
+
+// The bundles that were already built, so every replica of a hash doesn't build it again.
+// Only the newest hash of each component is kept, since older ones are only ever replaced.
+type bundleCache struct {
+	mux     sync.Mutex
+	bundles map[worker.ComponentID]*bundle
+}
+
+func newBundleCache() *bundleCache {
+	return &bundleCache{
+		bundles: make(map[worker.ComponentID]*bundle),
+	}
+}
+
+// The bundle built for compID, if there is one. It is kept until it is released.
+func (c *bundleCache) acquire(compID worker.ComponentID) (*bundle, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	b, ok := c.bundles[compID]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(b.builtAt) > bundleRetention {
+		c.evict(compID, b)
+		return nil, false
+	}
+	b.users++
+	return b, true
+}
+
+// Whether compID was built recently enough that activating it won't build it again
+func (c *bundleCache) contains(compID worker.ComponentID) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	b, ok := c.bundles[compID]
+	return ok && time.Since(b.builtAt) <= bundleRetention
+}
+
+// Keep the bundle that was just built for compID, replacing whatever was built for the component before.
+// The bundle is acquired, and needs to be released like one that was found.
+func (c *bundleCache) add(compID worker.ComponentID, path string) *bundle {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for cachedID, cached := range c.bundles {
+		sameComponent := cachedID.User == compID.User && cachedID.Repo == compID.Repo
+		if sameComponent || time.Since(cached.builtAt) > bundleRetention {
+			c.evict(cachedID, cached)
+		}
+	}
+
+	b := &bundle{path: path, builtAt: time.Now(), users: 1}
+	c.bundles[compID] = b
+	return b
+}
+
+func (c *bundleCache) release(b *bundle) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	b.users--
+	if b.evicted && b.users == 0 {
+		removeBundle(b)
+	}
+}
+
+// Must be called with the mutex held
+func (c *bundleCache) evict(compID worker.ComponentID, b *bundle) {
+	delete(c.bundles, compID)
+	b.evicted = true
+	if b.users == 0 {
+		removeBundle(b)
+	}
+}
+
+func removeBundle(b *bundle) {
+	err := os.Remove(b.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error.Println("Error removing bundle", b.path, err)
+	}
+}
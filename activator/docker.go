@@ -1,57 +1,62 @@
 package activator
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"v9_deployment_manager/log"
 )
 
 // Build Docker Image Based on Dockerfile
-func buildImageFromDockerfile(tarName string, tempRepoPath string) error {
+func buildImageFromDockerfile(ctx context.Context, tarName string, tempRepoPath string) error {
 	cmd := exec.Command("docker", "build", "-t", tarName, tempRepoPath)
-	return cmd.Run()
+	return runCommand(ctx, cmd)
 }
 
 // Build .tar from Docker Image
-func buildTarFromImage(tarName string) error {
+func buildTarFromImage(ctx context.Context, tarName string) error {
 	tarNameExt := tarName + ".tar"
 	cmd := exec.Command("docker", "save", tarName, "-o", tarNameExt)
-	return cmd.Run()
+	return runCommand(ctx, cmd)
 }
 
 // GZip tar
-func gzipTar(tarName string) error {
+func gzipTar(ctx context.Context, tarName string) error {
 	cmd := exec.Command("pigz", tarName)
 	cmd.Stdout = os.Stdout
-	return cmd.Run()
+	return runCommand(ctx, cmd)
 }
 
 // Build and Zip tar
-func buildAndZipTar(tarName string) (string, error) {
+func buildAndZipTar(ctx context.Context, tarName string) (string, error) {
 	// Build tar
 	log.Info.Println("Building tar from Docker image...")
-	err := buildTarFromImage(tarName)
+	err := buildTarFromImage(ctx, tarName)
 	if err != nil {
 		log.Error.Println("Error building tar from image", err)
+		os.Remove(tarName + ".tar")
 		return "", err
 	}
 
 	tarNameExt := tarName + ".tar"
 	// Gzip tar
 	log.Info.Println("Gzipping tar...")
-	err = gzipTar(tarNameExt)
+	err = gzipTar(ctx, tarNameExt)
 	if err != nil {
 		log.Error.Println("Failure to gzip", err)
+		// pigz leaves the tar (and maybe part of the .gz) behind if it doesn't finish
+		os.Remove(tarNameExt)
+		os.Remove(tarNameExt + ".gz")
 		return "", err
 	}
 	tarNameExt += ".gz"
 	return tarNameExt, nil
 }
 
-func buildComponentBundle(tarName string, clonedPath string) (string, error) {
+func buildComponentBundle(ctx context.Context, tarName string, clonedPath string) (string, error) {
 	// Build image
 	log.Info.Println("Building image from Dockerfile...")
-	err := buildImageFromDockerfile(tarName, clonedPath)
+	err := buildImageFromDockerfile(ctx, tarName, clonedPath)
 	if err != nil {
 		log.Error.Println("Error building image from Dockerfile", err)
 		return "", err
@@ -59,7 +64,7 @@ func buildComponentBundle(tarName string, clonedPath string) (string, error) {
 
 	// Build and Zip Tar
 	log.Info.Println("Building and zipping tar...")
-	tarNameExt, err := buildAndZipTar(tarName)
+	tarNameExt, err := buildAndZipTar(ctx, tarName)
 	if err != nil {
 		log.Error.Println("Failed to build and compress tar", err)
 		return "", err
@@ -2,7 +2,11 @@ package activator
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
 
 	"v9_deployment_manager/log"
@@ -12,14 +16,21 @@ import (
 )
 
 //Checkout head of specific repo
-func checkout(path string, hash string) error {
+func checkout(ctx context.Context, path string, hash string) error {
 	cmd := exec.Command("git", "checkout", hash)
 	cmd.Dir = path
-	return cmd.Run()
+	return runCommand(ctx, cmd)
+}
+
+//Fetch the head of a pull request into FETCH_HEAD
+func fetchPullRequest(ctx context.Context, path string, number int64) error {
+	cmd := exec.Command("git", "fetch", "origin", fmt.Sprintf("pull/%d/head", number))
+	cmd.Dir = path
+	return runCommand(ctx, cmd)
 }
 
 //Clone repo into temp dir
-func cloneRepo(repoName string) (string, error) {
+func cloneRepo(ctx context.Context, repoName string) (string, error) {
 	// Tempdir to clone the repository
 	dir, err := ioutil.TempDir("", ".git_")
 	if err != nil {
@@ -28,24 +39,25 @@ func cloneRepo(repoName string) (string, error) {
 	}
 
 	// TODO: Don't hardcode Github here
-	_, err = git.PlainClone(dir, false, &git.CloneOptions{
+	_, err = git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
 		URL: "https://github.com/" + repoName + ".git",
 	})
 
 	if err != nil {
 		log.Error.Println(err)
+		os.RemoveAll(dir)
 		return "", err
 	}
 	return dir, err
 }
 
-func getHash(repoFilePathAbs string) (string, error) {
+func getHash(ctx context.Context, repoFilePathAbs string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "HEAD")
 	cmd.Dir = repoFilePathAbs
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
+	err := runCommand(ctx, cmd)
 	if err != nil {
 		return "", err
 	}
@@ -54,30 +66,59 @@ func getHash(repoFilePathAbs string) (string, error) {
 	return hash, err
 }
 
+// Returned when what is being cloned can't be checked out, like a hash that isn't in the repo
+var ErrUnknownRevision = errors.New("revision is not in the repo")
+
 type cloneResult struct {
 	path string
 	hash string
 }
 
-func cloneAndSetHash(compID worker.ComponentID) (cloneResult, error) {
-	fullRepoName := compID.User + "/" + compID.Repo
+// Clone the repo and check out compID. HEAD means the head of the branch (or of the default branch, if it is empty).
+func cloneAndSetHash(ctx context.Context, compID worker.ComponentID, branch string) (cloneResult, error) {
+	// Previews are built from their pull request, in the repo the pull request was made to
+	repo, pullNumber, isPreview := worker.ParsePreviewRepo(compID.Repo)
+	if !isPreview {
+		repo = compID.Repo
+	}
+
+	fullRepoName := compID.User + "/" + repo
 	// Get Repo Contents
 	log.Info.Println("Cloning " + compID.Repo + "...")
-	clonedPath, err := cloneRepo(fullRepoName)
+	clonedPath, err := cloneRepo(ctx, fullRepoName)
 	if err != nil {
 		log.Error.Println("Error cloning repo:", err)
 		return cloneResult{}, err
 	}
 
-	err = checkout(clonedPath, compID.Hash)
+	revision := compID.Hash
+	if isPreview {
+		// Pull requests from forks are only in the repo under their pull request ref
+		err = fetchPullRequest(ctx, clonedPath, pullNumber)
+		if err != nil {
+			log.Error.Println("Error fetching pull request:", err)
+			os.RemoveAll(clonedPath)
+			return cloneResult{}, err
+		}
+		if compID.Hash == "HEAD" {
+			revision = "FETCH_HEAD"
+		}
+	} else if compID.Hash == "HEAD" && branch != "" {
+		revision = "origin/" + branch
+	}
+	err = checkout(ctx, clonedPath, revision)
 	if err != nil {
-		log.Error.Println("git checkout HEAD failed", err)
+		// Building the default branch instead would deploy the wrong thing under the wrong hash
+		log.Error.Println("git checkout", revision, "failed", err)
+		os.RemoveAll(clonedPath)
+		return cloneResult{}, fmt.Errorf("%w: %s: %v", ErrUnknownRevision, revision, err)
 	}
 
 	if compID.Hash == "HEAD" {
-		compID.Hash, err = getHash(clonedPath)
+		compID.Hash, err = getHash(ctx, clonedPath)
 		if err != nil {
 			log.Error.Println("Error getting hash from repo:", err)
+			os.RemoveAll(clonedPath)
 			return cloneResult{}, err
 		}
 	}
@@ -0,0 +1,35 @@
+package activator
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// Run the command, and kill it (along with everything it started) if ctx is cancelled first
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	// Put it in its own process group, so its children can be killed with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		// A negative pid kills the whole process group
+		killErr := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if killErr != nil {
+			cmd.Process.Kill()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
@@ -1,6 +1,7 @@
 package activator
 
 import (
+	"context"
 	"os"
 	"time"
 	"v9_deployment_manager/log"
@@ -10,7 +11,7 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func scpToWorker(workerURL string, source string, dest string, tarName string) error {
+func scpToWorker(ctx context.Context, workerURL string, source string, dest string, tarName string) error {
 	// Use SSH key authentication from the auth package
 	// we ignore the host key in this example, please change this if you use this library
 	clientConfig, err := auth.PrivateKey("ubuntu", "/home/ubuntu/.ssh/senior-design.pem", ssh.InsecureIgnoreHostKey())
@@ -35,6 +36,17 @@ func scpToWorker(workerURL string, source string, dest string, tarName string) e
 	// Close client connection after the file has been copied
 	defer client.Close()
 
+	// Closing the connection out from under the copy is the only way to stop it early
+	copied := make(chan struct{})
+	defer close(copied)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Conn.Close()
+		case <-copied:
+		}
+	}()
+
 	// Open a file
 	f, err := os.Open(source)
 	if err != nil {
@@ -49,5 +61,9 @@ func scpToWorker(workerURL string, source string, dest string, tarName string) e
 	// Usage: CopyFile(fileReader, remotePath, permission)
 	log.Info.Println("Copying " + tarName)
 	// 0664 = read/write for owner/group, and read only for everyone else
-	return client.CopyFile(f, dest, "0664")
+	err = client.CopyFile(f, dest, "0664")
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+// How a component's replicas follow its traffic. Components without a max are not autoscaled.
+type AutoscalingConfig struct {
+	MinReplicas int `json:"min_replicas"`
+	MaxReplicas int `json:"max_replicas"`
+	// How many requests per second each replica should be handling
+	TargetRequestsPerSecond float64 `json:"target_requests_per_second"`
+}
+
+type AutoscaledComponent struct {
+	Path     worker.ComponentPath
+	Replicas int
+	Config   AutoscalingConfig
+	// When the autoscaler last changed its replicas (zero if it never did)
+	LastScaled time.Time
+}
+
+// A change the autoscaler made to a component's replicas, and the traffic it saw when it made it
+type ScalingDecision struct {
+	ID           worker.ComponentPath `json:"id"`
+	FromReplicas int                  `json:"from_replicas"`
+	ToReplicas   int                  `json:"to_replicas"`
+
+	RequestsPerSecond       float64 `json:"requests_per_second"`
+	TargetRequestsPerSecond float64 `json:"target_requests_per_second"`
+	// How many stats samples the request rate is based on
+	Samples int    `json:"samples"`
+	Reason  string `json:"reason"`
+
+	DecisionTime time.Time `json:"decision_time"`
+}
+
+// Turn autoscaling on for the component (or off, if the config is all zeroes)
+func (driver *Driver) SetAutoscaling(compID worker.ComponentPath, config AutoscalingConfig) error {
+	updateQuery := `UPDATE components SET autoscale_min_replicas = NULLIF($1, 0),
+    autoscale_max_replicas = NULLIF($2, 0), autoscale_target_rps = NULLIF($3, 0)
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $4 AND components.github_repo = $5;`
+
+	_, err := driver.db.Exec(updateQuery, config.MinReplicas, config.MaxReplicas, config.TargetRequestsPerSecond,
+		compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component autoscaling: %w", err)
+	}
+	return err
+}
+
+// Every active component that has autoscaling turned on
+func (driver *Driver) FindAutoscaledComponents() ([]AutoscaledComponent, error) {
+	selectQuery := `SELECT u.github_username, c.github_repo, COALESCE(c.replicas, 1),
+    COALESCE(c.autoscale_min_replicas, 1), c.autoscale_max_replicas, c.autoscale_target_rps, c.last_scaled_time
+    FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+    WHERE c.deployment_intention = 'active' AND c.autoscale_max_replicas IS NOT NULL
+    AND c.autoscale_target_rps IS NOT NULL`
+
+	rows, err := driver.db.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not get autoscaled components: %w", err)
+	}
+	defer rows.Close()
+
+	autoscaled := make([]AutoscaledComponent, 0)
+	for rows.Next() {
+		var comp AutoscaledComponent
+		var lastScaled sql.NullTime
+		err = rows.Scan(&comp.Path.User, &comp.Path.Repo, &comp.Replicas, &comp.Config.MinReplicas,
+			&comp.Config.MaxReplicas, &comp.Config.TargetRequestsPerSecond, &lastScaled)
+		if err != nil {
+			return nil, fmt.Errorf("could not read autoscaled components: %w", err)
+		}
+		comp.LastScaled = lastScaled.Time
+		autoscaled = append(autoscaled, comp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return autoscaled, nil
+}
+
+// How many requests per second the component got across all its replicas since the given time, and how many stats
+// samples that is based on. Each sample counts the hits of one hash and color on one worker over its own window.
+// Every hit is counted once, over the whole time the samples cover, no matter which replica (or hash) served it.
+func (driver *Driver) FindRequestRate(compPath worker.ComponentPath, since time.Time) (float64, int, error) {
+	selectQuery := `SELECT COALESCE(SUM(s.hits), 0),
+    COALESCE(EXTRACT(EPOCH FROM MAX(s.received_time) -
+    MIN(s.received_time - s.stat_window_seconds * INTERVAL '1 second')), 0), COUNT(*) FROM v9.public.stats s
+    JOIN components c ON s.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+    WHERE u.github_username = $1 AND c.github_repo = $2 AND s.received_time >= $3`
+
+	var hits float64
+	var window float64
+	var samples int
+	err := driver.db.QueryRow(selectQuery, compPath.User, compPath.Repo, since).Scan(&hits, &window, &samples)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not get request rate: %w", err)
+	}
+	if window <= 0 {
+		return 0, 0, nil
+	}
+
+	return hits / window, samples, nil
+}
+
+// Set the replicas the autoscaler chose, and when it chose them (so its cooldowns outlive us)
+func (driver *Driver) SetAutoscaledReplicas(compID worker.ComponentPath, replicas int, scaledTime time.Time) error {
+	updateQuery := `UPDATE components SET replicas = $1, last_scaled_time = $2
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $3 AND components.github_repo = $4;`
+
+	_, err := driver.db.Exec(updateQuery, replicas, scaledTime, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update autoscaled replicas: %w", err)
+	}
+	return nil
+}
+
+func (driver *Driver) InsertScalingDecision(decision ScalingDecision) error {
+	compDBID, err := driver.FindComponentID(worker.ComponentID{User: decision.ID.User, Repo: decision.ID.Repo})
+	if err != nil {
+		return fmt.Errorf("error getting component ID for scaling decision: %w", err)
+	}
+
+	insertQuery := `INSERT INTO v9.public.scaling_decisions
+    (component_id, from_replicas, to_replicas, requests_per_second, target_requests_per_second, samples, reason,
+     decision_time)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = driver.db.Exec(insertQuery, compDBID, decision.FromReplicas, decision.ToReplicas,
+		decision.RequestsPerSecond, decision.TargetRequestsPerSecond, decision.Samples, decision.Reason,
+		decision.DecisionTime)
+	if err != nil {
+		return fmt.Errorf("error sending scaling decision to database: %w", err)
+	}
+
+	return nil
+}
+
+// Find a page of the scaling decisions made for a component, newest first
+func (driver *Driver) FindScalingDecisions(
+	compPath worker.ComponentPath,
+	limit int,
+	offset int) ([]ScalingDecision, error) {
+	selectQuery := `SELECT d.from_replicas, d.to_replicas, d.requests_per_second, d.target_requests_per_second,
+    d.samples, d.reason, d.decision_time FROM v9.public.scaling_decisions d
+    JOIN components c ON d.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+    WHERE u.github_username = $1 AND c.github_repo = $2
+    ORDER BY d.decision_time DESC LIMIT $3 OFFSET $4`
+
+	rows, err := driver.db.Query(selectQuery, compPath.User, compPath.Repo, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not get scaling decisions: %w", err)
+	}
+	defer rows.Close()
+
+	decisions := make([]ScalingDecision, 0)
+	for rows.Next() {
+		decision := ScalingDecision{ID: compPath}
+		err = rows.Scan(&decision.FromReplicas, &decision.ToReplicas, &decision.RequestsPerSecond,
+			&decision.TargetRequestsPerSecond, &decision.Samples, &decision.Reason, &decision.DecisionTime)
+		if err != nil {
+			return nil, fmt.Errorf("could not read scaling decisions: %w", err)
+		}
+		decisions = append(decisions, decision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return decisions, nil
+}
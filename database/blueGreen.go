@@ -0,0 +1,80 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+// Which hash of a blue/green component is live, and which one is kept around to flip back to
+type BlueGreenState struct {
+	LiveHash      string
+	LiveColor     string
+	PreviousHash  string
+	PreviousColor string
+	RetainUntil   time.Time
+}
+
+func (driver *Driver) SetBlueGreenState(compPath worker.ComponentPath, state BlueGreenState) error {
+	compDBID, err := driver.FindComponentID(worker.ComponentID{User: compPath.User, Repo: compPath.Repo})
+	if err != nil {
+		return err
+	}
+
+	upsertQuery := `INSERT INTO v9.public.blue_green_states(component_id, live_hash, live_color, previous_hash,
+    previous_color, retain_until) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+	ON CONFLICT (component_id) DO UPDATE SET live_hash = $2, live_color = $3, previous_hash = NULLIF($4, ''),
+    previous_color = NULLIF($5, ''), retain_until = $6`
+	// Nothing is retained until the first switch
+	retainUntil := sql.NullTime{Time: state.RetainUntil, Valid: !state.RetainUntil.IsZero()}
+	_, err = driver.db.Exec(upsertQuery, compDBID, state.LiveHash, state.LiveColor, state.PreviousHash,
+		state.PreviousColor, retainUntil)
+	if err != nil {
+		return fmt.Errorf("could not set blue/green state: %w", err)
+	}
+	return nil
+}
+
+func (driver *Driver) DeleteBlueGreenState(compPath worker.ComponentPath) error {
+	deleteQuery := `DELETE FROM v9.public.blue_green_states s
+	USING v9.public.components c, v9.public.users u
+	WHERE s.component_id = c.component_id AND c.user_id = u.user_id AND u.github_username = $1 AND c.github_repo = $2`
+	_, err := driver.db.Exec(deleteQuery, compPath.User, compPath.Repo)
+	if err != nil {
+		return fmt.Errorf("could not delete blue/green state: %w", err)
+	}
+	return nil
+}
+
+func (driver *Driver) FindBlueGreenStates() (map[worker.ComponentPath]BlueGreenState, error) {
+	selectQuery := `SELECT u.github_username, c.github_repo, s.live_hash, s.live_color, COALESCE(s.previous_hash, ''),
+    COALESCE(s.previous_color, ''), s.retain_until FROM v9.public.blue_green_states s
+    JOIN components c ON s.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id`
+	rows, err := driver.db.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not find blue/green states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[worker.ComponentPath]BlueGreenState)
+	for rows.Next() {
+		var compPath worker.ComponentPath
+		var state BlueGreenState
+		var retainUntil sql.NullTime
+		err = rows.Scan(&compPath.User, &compPath.Repo, &state.LiveHash, &state.LiveColor, &state.PreviousHash,
+			&state.PreviousColor, &retainUntil)
+		if err != nil {
+			return nil, fmt.Errorf("could not read blue/green state: %w", err)
+		}
+		state.RetainUntil = retainUntil.Time
+		states[compPath] = state
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
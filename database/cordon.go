@@ -0,0 +1,46 @@
+package database
+
+import "fmt"
+
+// Whether nothing new should be placed on a worker, and whether what it runs should be moved off it
+type WorkerCordon struct {
+	Cordoned bool
+	Draining bool
+}
+
+func (driver *Driver) SetWorkerCordon(workerName string, cordon WorkerCordon) error {
+	upsertQuery := `INSERT INTO v9.public.workers(worker_name, cordoned, draining) VALUES ($1, $2, $3)
+	ON CONFLICT (worker_name) DO UPDATE SET cordoned = $2, draining = $3`
+	_, err := driver.db.Exec(upsertQuery, workerName, cordon.Cordoned, cordon.Draining)
+	if err != nil {
+		return fmt.Errorf("could not set cordon of worker %s: %w", workerName, err)
+	}
+	return nil
+}
+
+// The cordoned workers, by name
+func (driver *Driver) FindCordonedWorkers() (map[string]WorkerCordon, error) {
+	selectQuery := `SELECT worker_name, cordoned, draining FROM v9.public.workers WHERE cordoned OR draining`
+	rows, err := driver.db.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not find cordoned workers: %w", err)
+	}
+	defer rows.Close()
+
+	cordons := make(map[string]WorkerCordon)
+	for rows.Next() {
+		var workerName string
+		var cordon WorkerCordon
+		err = rows.Scan(&workerName, &cordon.Cordoned, &cordon.Draining)
+		if err != nil {
+			return nil, fmt.Errorf("could not read cordoned worker: %w", err)
+		}
+		cordons[workerName] = cordon
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cordons, nil
+}
@@ -133,12 +133,12 @@ func (driver *Driver) InsertStats(workerID string, componentStatus worker.Compon
 	}
 
 	insertQuery := `INSERT INTO v9.public.stats
-    (worker_id, component_id, color, stat_window_seconds, hits,
-     avg_response_bytes, avg_ms_latency, ms_latency_percentiles)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+    (worker_id, component_id, hash, color, stat_window_seconds, hits,
+     avg_response_bytes, avg_ms_latency, ms_latency_percentiles, received_time)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())`
 
 	_, err = driver.db.Exec(
-		insertQuery, workerID, compID, componentStatus.Color, componentStatus.StatWindow,
+		insertQuery, workerID, compID, componentStatus.ID.Hash, componentStatus.Color, componentStatus.StatWindow,
 		componentStatus.Hits, componentStatus.AvgResponseBytes, componentStatus.AvgMsLatency, string(percentiles))
 	if err != nil {
 		return fmt.Errorf("error sending stats to database: %w", err)
@@ -185,11 +185,12 @@ func (driver *Driver) InsertLog(workerID string, compLog worker.ComponentLog) er
 	}
 
 	upsertQuery := `INSERT INTO v9.public.logs
-    (log_id, worker_id, component_id, execution_num, log_text, log_error, received_time)
-    VALUES ($1, $2, $3, $4, $5, $6, NOW())
-    ON CONFLICT (log_id) DO UPDATE SET log_text = $5, log_error = $6, received_time = NOW()`
+    (log_id, worker_id, component_id, hash, execution_num, log_text, log_error, received_time)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+    ON CONFLICT (log_id) DO UPDATE SET log_text = $6, log_error = $7, received_time = NOW()`
 
-	_, err = driver.db.Exec(upsertQuery, logID, workerID, compDBID, compLog.DedupNumber, logText, logError)
+	_, err = driver.db.Exec(
+		upsertQuery, logID, workerID, compDBID, compLog.ID.Hash, compLog.DedupNumber, logText, logError)
 	if err != nil {
 		return fmt.Errorf("error doing final log database update: %w", err)
 	}
@@ -239,37 +240,82 @@ func (driver *Driver) PurgeAllDeploymentEntries() error {
 	return nil
 }
 
-func (driver *Driver) FindActiveComponents() ([]worker.ComponentPath, error) {
-	selectQuery := `SELECT github_username, github_repo FROM v9.public.components c
+const (
+	ReplaceRollout   = "replace"
+	BlueGreenRollout = "blue_green"
+	CanaryRollout    = "canary"
+)
+
+type ActiveComponent struct {
+	Path            worker.ComponentPath
+	Replicas        int
+	RolloutStrategy string
+
+	// Labels a worker needs to have (with the same values) to run the component
+	RequiredLabels map[string]string
+	// A worker label no two replicas may share a value of (empty if replicas can go anywhere)
+	AntiAffinity string
+}
+
+// An active component that is in the database, but can't be deployed the way it is
+type UnreadableComponent struct {
+	Path worker.ComponentPath
+	Err  error
+}
+
+// The active components, along with the ones that couldn't be read (which don't stop the rest from being found)
+func (driver *Driver) FindActiveComponents() ([]ActiveComponent, []UnreadableComponent, error) {
+	selectQuery := `SELECT github_username, github_repo, COALESCE(replicas, 1), COALESCE(rollout_strategy, 'replace'),
+    COALESCE(required_labels, '{}'), COALESCE(anti_affinity, '') FROM v9.public.components c
     JOIN users u on c.user_id = u.user_id WHERE c.deployment_intention = 'active'`
 
 	rows, err := driver.db.Query(selectQuery)
 	if err != nil {
-		return nil, fmt.Errorf("could not get active components: %w", err)
+		return nil, nil, fmt.Errorf("could not get active components: %w", err)
 	}
 	defer rows.Close()
 
-	activeComponents := make([]worker.ComponentPath, 0)
+	activeComponents := make([]ActiveComponent, 0)
+	unreadable := make([]UnreadableComponent, 0)
 	for rows.Next() {
 		var username string
 		var repo string
+		var replicas int
+		var rolloutStrategy string
+		var requiredLabelsJSON []byte
+		var antiAffinity string
 
-		if err = rows.Scan(&username, &repo); err != nil {
+		if err = rows.Scan(&username, &repo, &replicas, &rolloutStrategy, &requiredLabelsJSON, &antiAffinity); err != nil {
 			// Check for a scan error.
 			// Query rows will be closed with defer.
 			log.Fatal(err)
 		}
-		activeComponents = append(activeComponents, worker.ComponentPath{
+		path := worker.ComponentPath{
 			User: username,
 			Repo: repo,
+		}
+		var requiredLabels map[string]string
+		if err = json.Unmarshal(requiredLabelsJSON, &requiredLabels); err != nil {
+			unreadable = append(unreadable, UnreadableComponent{
+				Path: path,
+				Err:  fmt.Errorf("could not read required labels of %s/%s: %w", username, repo, err),
+			})
+			continue
+		}
+		activeComponents = append(activeComponents, ActiveComponent{
+			Path:            path,
+			Replicas:        replicas,
+			RolloutStrategy: rolloutStrategy,
+			RequiredLabels:  requiredLabels,
+			AntiAffinity:    antiAffinity,
 		})
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return activeComponents, nil
+	return activeComponents, unreadable, nil
 }
 
 func (driver *Driver) SetDeploymentIntention(compID worker.ComponentPath, status string) error {
@@ -283,3 +329,254 @@ func (driver *Driver) SetDeploymentIntention(compID worker.ComponentPath, status
 	}
 	return err
 }
+
+// The component's deployment intention (empty if there is no such component)
+func (driver *Driver) FindDeploymentIntention(compID worker.ComponentPath) (string, error) {
+	selectQuery := `SELECT c.deployment_intention FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE u.github_username = $1 AND c.github_repo = $2`
+
+	var intention string
+	err := driver.db.QueryRow(selectQuery, compID.User, compID.Repo).Scan(&intention)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not find component deployment intention: %w", err)
+	}
+	return intention, nil
+}
+
+func (driver *Driver) SetReplicas(compID worker.ComponentPath, replicas int) error {
+	updateQuery := `UPDATE components SET replicas = $1
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $2 AND components.github_repo = $3;`
+
+	_, err := driver.db.Exec(updateQuery, replicas, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component replicas: %w", err)
+	}
+	return err
+}
+
+func (driver *Driver) SetRolloutStrategy(compID worker.ComponentPath, strategy string) error {
+	updateQuery := `UPDATE components SET rollout_strategy = $1
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $2 AND components.github_repo = $3;`
+
+	_, err := driver.db.Exec(updateQuery, strategy, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component rollout strategy: %w", err)
+	}
+	return err
+}
+
+func (driver *Driver) SetPlacementConstraints(
+	compID worker.ComponentPath,
+	requiredLabels map[string]string,
+	antiAffinity string) error {
+	requiredLabelsJSON, err := json.Marshal(requiredLabels)
+	if err != nil {
+		return err
+	}
+
+	updateQuery := `UPDATE components SET required_labels = $1, anti_affinity = NULLIF($2, '')
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $3 AND components.github_repo = $4;`
+
+	_, err = driver.db.Exec(updateQuery, requiredLabelsJSON, antiAffinity, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component placement constraints: %w", err)
+	}
+	return err
+}
+
+// Remember which hash the component should be running, so we still know after a restart (empty forgets it)
+func (driver *Driver) SetDesiredHash(compID worker.ComponentPath, hash string) error {
+	updateQuery := `UPDATE components SET desired_hash = NULLIF($1, '')
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $2 AND components.github_repo = $3;`
+
+	_, err := driver.db.Exec(updateQuery, hash, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component desired hash: %w", err)
+	}
+	return err
+}
+
+func (driver *Driver) FindDesiredHashes() (map[worker.ComponentPath]string, error) {
+	selectQuery := `SELECT u.github_username, c.github_repo, c.desired_hash FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE c.desired_hash IS NOT NULL`
+	return driver.findComponentHashes(selectQuery, "desired")
+}
+
+// The branch pushes are deployed from (empty if the component follows the repo's default branch)
+func (driver *Driver) FindTrackedBranch(compID worker.ComponentID) (string, error) {
+	selectQuery := `SELECT COALESCE(c.tracked_branch, '') FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE u.github_username = $1 AND c.github_repo = $2`
+
+	var branch string
+	err := driver.db.QueryRow(selectQuery, compID.User, compID.Repo).Scan(&branch)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not find component tracked branch: %w", err)
+	}
+	return branch, nil
+}
+
+// Deploy pushes to the branch (an empty branch goes back to the repo's default branch)
+func (driver *Driver) SetTrackedBranch(compID worker.ComponentPath, branch string) error {
+	updateQuery := `UPDATE components SET tracked_branch = NULLIF($1, '')
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $2 AND components.github_repo = $3;`
+
+	_, err := driver.db.Exec(updateQuery, branch, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component tracked branch: %w", err)
+	}
+	return err
+}
+
+// Hold the component on the hash, no matter what gets pushed (an empty hash unpins it)
+func (driver *Driver) SetPinnedHash(compID worker.ComponentPath, hash string) error {
+	updateQuery := `UPDATE components SET pinned_hash = NULLIF($1, '')
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $2 AND components.github_repo = $3;`
+
+	_, err := driver.db.Exec(updateQuery, hash, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component pinned hash: %w", err)
+	}
+	return err
+}
+
+func (driver *Driver) FindPinnedHashes() (map[worker.ComponentPath]string, error) {
+	selectQuery := `SELECT u.github_username, c.github_repo, c.pinned_hash FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE c.pinned_hash IS NOT NULL`
+	return driver.findComponentHashes(selectQuery, "pinned")
+}
+
+func (driver *Driver) findComponentHashes(selectQuery string, kind string) (map[worker.ComponentPath]string, error) {
+	rows, err := driver.db.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not find %s hashes: %w", kind, err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[worker.ComponentPath]string)
+	for rows.Next() {
+		var compPath worker.ComponentPath
+		var hash string
+		err = rows.Scan(&compPath.User, &compPath.Repo, &hash)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s hash: %w", kind, err)
+		}
+		hashes[compPath] = hash
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// Remember that a hash was rolled back, so we don't deploy it again
+func (driver *Driver) MarkBadHash(compID worker.ComponentID, reason string) error {
+	compDBID, err := driver.FindComponentID(compID)
+	if err != nil {
+		return err
+	}
+
+	insertQuery := `INSERT INTO v9.public.bad_hashes(component_id, hash, reason, marked_time)
+	VALUES ($1, $2, $3, NOW()) ON CONFLICT (component_id, hash) DO UPDATE SET reason = $3, marked_time = NOW()`
+	_, err = driver.db.Exec(insertQuery, compDBID, compID.Hash, reason)
+	if err != nil {
+		return fmt.Errorf("could not mark bad hash: %w", err)
+	}
+	return nil
+}
+
+func (driver *Driver) IsBadHash(compID worker.ComponentID) (bool, error) {
+	selectQuery := `SELECT COUNT(*) FROM v9.public.bad_hashes b
+    JOIN components c ON b.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+    WHERE u.github_username = $1 AND c.github_repo = $2 AND b.hash = $3`
+
+	var count int
+	err := driver.db.QueryRow(selectQuery, compID.User, compID.Repo, compID.Hash).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("could not check for bad hash: %w", err)
+	}
+	return count > 0, nil
+}
+
+type RunningComponent struct {
+	WorkerName string
+	ID         worker.ComponentID
+}
+
+// What the PollingPopulator last saw running on each worker
+func (driver *Driver) FindCurrentlyRunning() ([]RunningComponent, error) {
+	selectQuery := `SELECT w.worker_name, u.github_username, c.github_repo, r.hash FROM v9.public.currently_running r
+    JOIN workers w ON r.worker_id = w.worker_id
+    JOIN components c ON r.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id`
+
+	rows, err := driver.db.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not get currently running components: %w", err)
+	}
+	defer rows.Close()
+
+	running := make([]RunningComponent, 0)
+	for rows.Next() {
+		var runningComp RunningComponent
+		err = rows.Scan(&runningComp.WorkerName, &runningComp.ID.User, &runningComp.ID.Repo, &runningComp.ID.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("could not read currently running components: %w", err)
+		}
+		running = append(running, runningComp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return running, nil
+}
+
+const (
+	DriftEvent    = "drift"
+	FailoverEvent = "failover"
+)
+
+// Record something notable that happened to a component. The worker name can be left empty.
+func (driver *Driver) RecordEvent(
+	compPath worker.ComponentPath,
+	workerName string,
+	eventType string,
+	reason string) error {
+	compDBID, err := driver.FindComponentID(worker.ComponentID{User: compPath.User, Repo: compPath.Repo})
+	if err != nil {
+		return err
+	}
+
+	var eventWorker *string
+	if workerName != "" {
+		eventWorker = &workerName
+	}
+
+	insertQuery := `INSERT INTO v9.public.events(component_id, worker_name, event_type, reason, event_time)
+	VALUES ($1, $2, $3, $4, NOW())`
+	_, err = driver.db.Exec(insertQuery, compDBID, eventWorker, eventType, reason)
+	if err != nil {
+		return fmt.Errorf("could not record %s event: %w", eventType, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+const (
+	ActivateAction   = "activate"
+	DeactivateAction = "deactivate"
+)
+
+const (
+	ClonePhase    = "clone"
+	BuildPhase    = "build"
+	TransferPhase = "transfer"
+	ActivatePhase = "activate"
+)
+
+const (
+	SucceededOutcome = "succeeded"
+	FailedOutcome    = "failed"
+	CancelledOutcome = "cancelled"
+)
+
+type DeploymentRecord struct {
+	ID        worker.ComponentID `json:"id"`
+	Action    string             `json:"action"`
+	WorkerURL string             `json:"worker_url"`
+	Reason    string             `json:"reason"`
+
+	StartTime        time.Time        `json:"start_time"`
+	EndTime          time.Time        `json:"end_time"`
+	PhaseDurationsMs map[string]int64 `json:"phase_durations_ms"`
+
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+func NewDeploymentRecord(compID worker.ComponentID, workerURL string, action string, reason string) DeploymentRecord {
+	return DeploymentRecord{
+		ID:               compID,
+		Action:           action,
+		WorkerURL:        workerURL,
+		Reason:           reason,
+		StartTime:        time.Now(),
+		PhaseDurationsMs: make(map[string]int64),
+	}
+}
+
+func (record *DeploymentRecord) EndPhase(phase string, phaseStart time.Time) {
+	record.PhaseDurationsMs[phase] = time.Since(phaseStart).Milliseconds()
+}
+
+func (record *DeploymentRecord) Finish(err error) {
+	record.EndTime = time.Now()
+	if errors.Is(err, context.Canceled) {
+		record.Outcome = CancelledOutcome
+		record.Error = err.Error()
+	} else if err != nil {
+		record.Outcome = FailedOutcome
+		record.Error = err.Error()
+	} else {
+		record.Outcome = SucceededOutcome
+	}
+}
+
+func (driver *Driver) InsertDeploymentRecord(record DeploymentRecord) error {
+	compDBID, err := driver.FindComponentID(record.ID)
+	if err != nil {
+		return fmt.Errorf("error getting component ID for deployment history: %w", err)
+	}
+
+	phaseDurations, err := json.Marshal(record.PhaseDurationsMs)
+	if err != nil {
+		return fmt.Errorf("error marshaling phase durations: %w", err)
+	}
+
+	var deploymentError *string
+	if record.Error != "" {
+		deploymentError = &record.Error
+	}
+
+	insertQuery := `INSERT INTO v9.public.deployment_history
+    (component_id, hash, action, worker_url, reason, start_time, end_time, phase_durations_ms, outcome, error)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err = driver.db.Exec(
+		insertQuery, compDBID, record.ID.Hash, record.Action, record.WorkerURL, record.Reason,
+		record.StartTime, record.EndTime, string(phaseDurations), record.Outcome, deploymentError)
+	if err != nil {
+		return fmt.Errorf("error sending deployment history to database: %w", err)
+	}
+
+	return nil
+}
+
+// Find a page of the deployment history of a component, newest first
+func (driver *Driver) FindDeploymentHistory(
+	compPath worker.ComponentPath,
+	limit int,
+	offset int) ([]DeploymentRecord, error) {
+	selectQuery := `SELECT d.hash, d.action, d.worker_url, d.reason, d.start_time, d.end_time,
+    d.phase_durations_ms, d.outcome, d.error FROM v9.public.deployment_history d
+    JOIN components c ON d.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+    WHERE u.github_username = $1 AND c.github_repo = $2
+    ORDER BY d.start_time DESC LIMIT $3 OFFSET $4`
+
+	rows, err := driver.db.Query(selectQuery, compPath.User, compPath.Repo, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not get deployment history: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]DeploymentRecord, 0)
+	for rows.Next() {
+		record := DeploymentRecord{
+			ID: worker.ComponentID{User: compPath.User, Repo: compPath.Repo},
+		}
+		var phaseDurations string
+		var deploymentError *string
+
+		err = rows.Scan(&record.ID.Hash, &record.Action, &record.WorkerURL, &record.Reason, &record.StartTime,
+			&record.EndTime, &phaseDurations, &record.Outcome, &deploymentError)
+		if err != nil {
+			return nil, fmt.Errorf("could not read deployment history: %w", err)
+		}
+
+		if err = json.Unmarshal([]byte(phaseDurations), &record.PhaseDurationsMs); err != nil {
+			return nil, fmt.Errorf("error unmarshaling phase durations: %w", err)
+		}
+		if deploymentError != nil {
+			record.Error = *deploymentError
+		}
+
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+// How a single hash of a component has been doing, based on the stats and logs the PollingPopulator collected
+type HashHealth struct {
+	Hits         float64
+	AvgMsLatency float64
+	// The highest percentile the workers report (p99)
+	P99MsLatency float64
+
+	Executions int
+	Errors     int
+}
+
+func (health HashHealth) ErrorRate() float64 {
+	if health.Executions == 0 {
+		return 0
+	}
+	return float64(health.Errors) / float64(health.Executions)
+}
+
+// Find how the hash did between `from` and `to`
+func (driver *Driver) FindHashHealth(
+	compPath worker.ComponentPath,
+	hash string,
+	from time.Time,
+	to time.Time) (HashHealth, error) {
+	statsQuery := `SELECT s.hits, s.avg_ms_latency, s.ms_latency_percentiles FROM v9.public.stats s
+    JOIN components c ON s.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+    WHERE u.github_username = $1 AND c.github_repo = $2 AND s.hash = $3
+    AND s.received_time BETWEEN $4 AND $5 AND s.hits > 0`
+
+	rows, err := driver.db.Query(statsQuery, compPath.User, compPath.Repo, hash, from, to)
+	if err != nil {
+		return HashHealth{}, fmt.Errorf("could not get stats for hash %s: %w", hash, err)
+	}
+	defer rows.Close()
+
+	// Weight every sample by how many hits it saw
+	var health HashHealth
+	var weightedLatency float64
+	var weightedP99 float64
+	for rows.Next() {
+		var hits float64
+		var avgMsLatency float64
+		var percentilesJSON string
+
+		if err = rows.Scan(&hits, &avgMsLatency, &percentilesJSON); err != nil {
+			return HashHealth{}, fmt.Errorf("could not read stats for hash %s: %w", hash, err)
+		}
+
+		var percentiles []float64
+		if err = json.Unmarshal([]byte(percentilesJSON), &percentiles); err != nil {
+			return HashHealth{}, fmt.Errorf("error unmarshaling latency percentiles: %w", err)
+		}
+
+		health.Hits += hits
+		weightedLatency += hits * avgMsLatency
+		if len(percentiles) > 0 {
+			weightedP99 += hits * percentiles[len(percentiles)-1]
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return HashHealth{}, err
+	}
+
+	if health.Hits > 0 {
+		health.AvgMsLatency = weightedLatency / health.Hits
+		health.P99MsLatency = weightedP99 / health.Hits
+	}
+
+	logsQuery := `SELECT COUNT(*), COUNT(l.log_error) FROM v9.public.logs l
+    JOIN components c ON l.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+    WHERE u.github_username = $1 AND c.github_repo = $2 AND l.hash = $3 AND l.received_time BETWEEN $4 AND $5`
+
+	err = driver.db.QueryRow(logsQuery, compPath.User, compPath.Repo, hash, from, to).Scan(
+		&health.Executions, &health.Errors)
+	if err != nil {
+		return HashHealth{}, fmt.Errorf("could not get logs for hash %s: %w", hash, err)
+	}
+
+	return health, nil
+}
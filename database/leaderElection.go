@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"v9_deployment_manager/log"
+)
+
+// The key of the Postgres advisory lock held by the leading deployment manager ("v9_mgmt")
+const leaderLockKey = 0x76395f6d676d74
+
+// Makes sure only one deployment manager manages the workers at a time. The leader holds a session-level
+// advisory lock on its own connection, so the lock goes away as soon as the leader (or its connection) does.
+type LeaderElection struct {
+	driver       *Driver
+	advertiseURL string
+
+	mux      sync.Mutex
+	conn     *sql.Conn
+	isLeader bool
+}
+
+func NewLeaderElection(driver *Driver, advertiseURL string) *LeaderElection {
+	return &LeaderElection{
+		driver:       driver,
+		advertiseURL: advertiseURL,
+	}
+}
+
+func (e *LeaderElection) IsLeader() bool {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	return e.isLeader
+}
+
+// Block until we are the leader, trying to become it every interval
+func (e *LeaderElection) Campaign(interval time.Duration) {
+	for {
+		elected, err := e.tryToLead()
+		if err != nil {
+			log.Warning.Println("Could not try to become the leader:", err)
+		} else if elected {
+			log.Info.Println("Became the leader")
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (e *LeaderElection) tryToLead() (bool, error) {
+	// Followers would have nowhere to send what they get
+	if e.advertiseURL == "" {
+		return false, errors.New("refusing to lead without a URL to advertise")
+	}
+
+	ctx := context.Background()
+	conn, err := e.driver.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("could not get a connection for leader election: %w", err)
+	}
+
+	var elected bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockKey).Scan(&elected)
+	if err != nil || !elected {
+		conn.Close()
+		if err != nil {
+			return false, fmt.Errorf("could not try to take the leader lock: %w", err)
+		}
+		return false, nil
+	}
+
+	// Let the followers know where to send what they get
+	upsertQuery := `INSERT INTO v9.public.manager_leader(lock_key, leader_url, elected_time) VALUES ($1, $2, NOW())
+	ON CONFLICT (lock_key) DO UPDATE SET leader_url = $2, elected_time = NOW()`
+	_, err = conn.ExecContext(ctx, upsertQuery, leaderLockKey, e.advertiseURL)
+	if err != nil {
+		conn.Close()
+		return false, fmt.Errorf("could not record the leader: %w", err)
+	}
+
+	e.mux.Lock()
+	e.conn = conn
+	e.isLeader = true
+	e.mux.Unlock()
+	return true, nil
+}
+
+// Check every interval that we still hold the lock, and call onLost (once) if we don't
+func (e *LeaderElection) WatchLeadership(interval time.Duration, onLost func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.mux.Lock()
+			conn := e.conn
+			e.mux.Unlock()
+
+			// The lock belongs to the connection's session, so as long as the session is alive, we hold it
+			var alive int
+			err := conn.QueryRowContext(context.Background(), `SELECT 1`).Scan(&alive)
+			if err != nil {
+				e.mux.Lock()
+				e.isLeader = false
+				e.mux.Unlock()
+				conn.Close()
+
+				onLost(err)
+				return
+			}
+		}
+	}()
+}
+
+// Where the current leader can be reached (empty if nobody has told us)
+func (driver *Driver) FindLeaderURL() (string, error) {
+	var leaderURL string
+	selectQuery := `SELECT leader_url FROM v9.public.manager_leader WHERE lock_key = $1`
+	err := driver.db.QueryRow(selectQuery, leaderLockKey).Scan(&leaderURL)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not find the leader: %w", err)
+	}
+	return leaderURL, nil
+}
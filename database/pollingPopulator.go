@@ -1,23 +1,21 @@
 package database
 
 import (
-	"fmt"
 	"time"
 	"v9_deployment_manager/log"
 	"v9_deployment_manager/worker"
 )
 
 type PollingPopulator struct {
-	workers []*worker.V9Worker
+	workers *WorkerRegistry
 	driver  *Driver
 }
 
 func (populator *PollingPopulator) pollWorkersToDatabase() {
-	workerIDs := make([]string, len(populator.workers))
-	for i := range populator.workers {
-		// TODO: This name should come from the worker itself
-		name := fmt.Sprintf("worker_%d", i)
-		id, err := populator.driver.FindWorkerID(name)
+	workers := populator.workers.Workers()
+	workerIDs := make([]string, len(workers))
+	for i, w := range workers {
+		id, err := populator.driver.FindWorkerID(w.Name)
 		if err != nil {
 			log.Error.Println("error getting worker id:", err)
 			continue
@@ -25,7 +23,7 @@ func (populator *PollingPopulator) pollWorkersToDatabase() {
 		workerIDs[i] = id
 	}
 
-	for i, w := range populator.workers {
+	for i, w := range workers {
 		// TODO: Populate the CPU usage/memory usage/network usage
 		status, err := w.Status()
 		if err != nil {
@@ -54,7 +52,7 @@ func (populator *PollingPopulator) pollWorkersToDatabase() {
 		}
 	}
 
-	for i, w := range populator.workers {
+	for i, w := range workers {
 		logs, err := w.Logs()
 		if err != nil {
 			log.Warning.Println("error getting worker logs:", err)
@@ -74,7 +72,7 @@ func (populator *PollingPopulator) pollWorkersToDatabase() {
 	}
 }
 
-func StartPollingPopulator(workers []*worker.V9Worker, cadence time.Duration, driver *Driver) {
+func StartPollingPopulator(workers *WorkerRegistry, cadence time.Duration, driver *Driver) {
 	populator := PollingPopulator{
 		workers: workers,
 		driver:  driver,
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+const (
+	MaxActiveComponentsLimit = "max_active_components"
+	MaxReplicasLimit         = "max_replicas"
+	MaxBuildsPerHourLimit    = "max_builds_per_hour"
+)
+
+// What a user is allowed to use. Nil means there is no limit (and zero means nothing is allowed).
+type Quota struct {
+	MaxActiveComponents *int
+	// Across all of the user's active components
+	MaxReplicas      *int
+	MaxBuildsPerHour *int
+}
+
+type QuotaError struct {
+	User      string
+	Limit     string
+	Max       int
+	Requested int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s of %s is %d, but this needs %d", e.Limit, e.User, e.Max, e.Requested)
+}
+
+// Returns an error naming the limit, if the amount goes over it
+func (q Quota) check(user string, limit string, max *int, requested int) error {
+	if max != nil && requested > *max {
+		return &QuotaError{User: user, Limit: limit, Max: *max, Requested: requested}
+	}
+	return nil
+}
+
+func (q Quota) CheckActiveComponents(user string, requested int) error {
+	return q.check(user, MaxActiveComponentsLimit, q.MaxActiveComponents, requested)
+}
+
+func (q Quota) CheckReplicas(user string, requested int) error {
+	return q.check(user, MaxReplicasLimit, q.MaxReplicas, requested)
+}
+
+func (q Quota) CheckBuildsPerHour(user string, requested int) error {
+	return q.check(user, MaxBuildsPerHourLimit, q.MaxBuildsPerHour, requested)
+}
+
+// The user's quota. Users without their own quota get the default one (the one without a user), if there is one.
+func (driver *Driver) FindQuota(githubUsername string) (Quota, error) {
+	selectQuery := `SELECT q.max_active_components, q.max_replicas, q.max_builds_per_hour FROM v9.public.quotas q
+    LEFT JOIN users u ON q.user_id = u.user_id
+	WHERE u.github_username = $1 OR q.user_id IS NULL
+	ORDER BY q.user_id IS NULL LIMIT 1`
+
+	var quota Quota
+	err := driver.db.QueryRow(selectQuery, githubUsername).Scan(
+		&quota.MaxActiveComponents, &quota.MaxReplicas, &quota.MaxBuildsPerHour)
+	if err == sql.ErrNoRows {
+		return Quota{}, nil
+	}
+	if err != nil {
+		return Quota{}, fmt.Errorf("could not find quota: %w", err)
+	}
+	return quota, nil
+}
+
+// How many components the user has active, and how many replicas they want between them, leaving out `except`
+func (driver *Driver) findActiveUsage(except worker.ComponentPath) (int, int, error) {
+	selectQuery := `SELECT COUNT(*), COALESCE(SUM(c.replicas), 0) FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE u.github_username = $1 AND c.github_repo <> $2 AND c.deployment_intention = 'active'`
+
+	var components int
+	var replicas int
+	err := driver.db.QueryRow(selectQuery, except.User, except.Repo).Scan(&components, &replicas)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not find active usage: %w", err)
+	}
+	return components, replicas, nil
+}
+
+// Check that the user is allowed to make the component active, with its current number of replicas
+func (driver *Driver) CheckActivationQuota(compID worker.ComponentPath) error {
+	var replicas int
+	selectQuery := `SELECT COALESCE(c.replicas, 1) FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE u.github_username = $1 AND c.github_repo = $2`
+	err := driver.db.QueryRow(selectQuery, compID.User, compID.Repo).Scan(&replicas)
+	if err == sql.ErrNoRows {
+		// It will get the default number of replicas once it exists
+		replicas = 1
+	} else if err != nil {
+		return fmt.Errorf("could not find component replicas: %w", err)
+	}
+
+	return driver.checkUsageQuota(compID, replicas)
+}
+
+// Check that the user is allowed to give the component that many replicas (if it is active, or becomes active)
+func (driver *Driver) CheckReplicasQuota(compID worker.ComponentPath, replicas int) error {
+	intention, err := driver.FindDeploymentIntention(compID)
+	if err != nil {
+		return err
+	}
+	// Replicas of inactive components don't use anything until they are activated (which checks them again)
+	if intention != "active" {
+		return nil
+	}
+	return driver.checkUsageQuota(compID, replicas)
+}
+
+func (driver *Driver) checkUsageQuota(compID worker.ComponentPath, replicas int) error {
+	quota, err := driver.FindQuota(compID.User)
+	if err != nil {
+		return err
+	}
+	otherComponents, otherReplicas, err := driver.findActiveUsage(compID)
+	if err != nil {
+		return err
+	}
+
+	err = quota.CheckActiveComponents(compID.User, otherComponents+1)
+	if err != nil {
+		return err
+	}
+	return quota.CheckReplicas(compID.User, otherReplicas+replicas)
+}
+
+// How many times the user's components were built since the given time. Activations that reused a bundle built for
+// another replica have no build phase, so they don't count.
+func (driver *Driver) CountBuildsSince(githubUsername string, since time.Time) (int, error) {
+	selectQuery := `SELECT COUNT(*) FROM v9.public.deployment_history d
+    JOIN components c ON d.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id
+	WHERE u.github_username = $1 AND d.action = $2 AND d.start_time >= $3 AND d.phase_durations_ms ? $4`
+
+	var builds int
+	err := driver.db.QueryRow(selectQuery, githubUsername, ActivateAction, since, BuildPhase).Scan(&builds)
+	if err != nil {
+		return 0, fmt.Errorf("could not count builds: %w", err)
+	}
+	return builds, nil
+}
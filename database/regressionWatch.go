@@ -0,0 +1,91 @@
+package database
+
+import (
+	"fmt"
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+// A hash that went live and is being compared against the last known-good hash of its component
+type RegressionWatch struct {
+	Hash         string
+	PreviousHash string
+	LiveSince    time.Time
+	Done         bool
+}
+
+func (driver *Driver) SetRegressionWatch(compPath worker.ComponentPath, watch RegressionWatch) error {
+	compDBID, err := driver.FindComponentID(worker.ComponentID{User: compPath.User, Repo: compPath.Repo})
+	if err != nil {
+		return err
+	}
+
+	upsertQuery := `INSERT INTO v9.public.regression_watches(component_id, hash, previous_hash, live_since, done)
+	VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+	ON CONFLICT (component_id) DO UPDATE SET hash = $2, previous_hash = NULLIF($3, ''), live_since = $4, done = $5`
+	_, err = driver.db.Exec(upsertQuery, compDBID, watch.Hash, watch.PreviousHash, watch.LiveSince, watch.Done)
+	if err != nil {
+		return fmt.Errorf("could not set regression watch: %w", err)
+	}
+	return nil
+}
+
+func (driver *Driver) DeleteRegressionWatch(compPath worker.ComponentPath) error {
+	deleteQuery := `DELETE FROM v9.public.regression_watches w
+	USING v9.public.components c, v9.public.users u
+	WHERE w.component_id = c.component_id AND c.user_id = u.user_id AND u.github_username = $1 AND c.github_repo = $2`
+	_, err := driver.db.Exec(deleteQuery, compPath.User, compPath.Repo)
+	if err != nil {
+		return fmt.Errorf("could not delete regression watch: %w", err)
+	}
+	return nil
+}
+
+func (driver *Driver) FindRegressionWatches() (map[worker.ComponentPath]RegressionWatch, error) {
+	selectQuery := `SELECT u.github_username, c.github_repo, w.hash, COALESCE(w.previous_hash, ''), w.live_since, w.done
+    FROM v9.public.regression_watches w
+    JOIN components c ON w.component_id = c.component_id
+    JOIN users u ON c.user_id = u.user_id`
+	rows, err := driver.db.Query(selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not find regression watches: %w", err)
+	}
+	defer rows.Close()
+
+	watches := make(map[worker.ComponentPath]RegressionWatch)
+	for rows.Next() {
+		var compPath worker.ComponentPath
+		var watch RegressionWatch
+		err = rows.Scan(&compPath.User, &compPath.Repo, &watch.Hash, &watch.PreviousHash, &watch.LiveSince, &watch.Done)
+		if err != nil {
+			return nil, fmt.Errorf("could not read regression watch: %w", err)
+		}
+		watches[compPath] = watch
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return watches, nil
+}
+
+// The hash the component goes back to if a new one regresses (empty to forget it)
+func (driver *Driver) SetKnownGoodHash(compID worker.ComponentPath, hash string) error {
+	updateQuery := `UPDATE components SET known_good_hash = NULLIF($1, '')
+	FROM users
+	WHERE users.user_id = components.user_id AND users.github_username = $2 AND components.github_repo = $3;`
+
+	_, err := driver.db.Exec(updateQuery, hash, compID.User, compID.Repo)
+	if err != nil {
+		return fmt.Errorf("could not update component known-good hash: %w", err)
+	}
+	return err
+}
+
+func (driver *Driver) FindKnownGoodHashes() (map[worker.ComponentPath]string, error) {
+	selectQuery := `SELECT u.github_username, c.github_repo, c.known_good_hash FROM v9.public.components c
+    JOIN users u ON c.user_id = u.user_id
+	WHERE c.known_good_hash IS NOT NULL`
+	return driver.findComponentHashes(selectQuery, "known-good")
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// Returned for workers that never registered and aren't in V9_WORKERS
+var ErrUnknownWorker = errors.New("worker is not registered")
+
+// Returned when registering a name that a worker with a different URL is still using
+var ErrWorkerNameTaken = errors.New("worker name is in use")
+
+// How long a registered worker can go without a heartbeat before it is forgotten altogether
+const registrationRetention = time.Hour
+
+// The workers the deployment manager knows about. Workers register themselves and then keep sending heartbeats,
+// while workers from V9_WORKERS are static -- they never register or send heartbeats, and never expire.
+type WorkerRegistry struct {
+	driver           *Driver
+	heartbeatTimeout time.Duration
+
+	mux            sync.Mutex
+	workers        map[string]*worker.V9Worker
+	lastHeartbeats map[string]time.Time
+	static         map[string]bool
+}
+
+func NewWorkerRegistry(driver *Driver, heartbeatTimeout time.Duration) *WorkerRegistry {
+	return &WorkerRegistry{
+		driver:           driver,
+		heartbeatTimeout: heartbeatTimeout,
+		workers:          make(map[string]*worker.V9Worker),
+		lastHeartbeats:   make(map[string]time.Time),
+		static:           make(map[string]bool),
+	}
+}
+
+// Pick up the workers that registered before we (re)started
+func (reg *WorkerRegistry) Load() error {
+	selectQuery := `SELECT worker_name, worker_url, labels, last_heartbeat FROM v9.public.workers
+	WHERE worker_url IS NOT NULL`
+	rows, err := reg.driver.db.Query(selectQuery)
+	if err != nil {
+		return fmt.Errorf("could not load registered workers: %w", err)
+	}
+	defer rows.Close()
+
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	for rows.Next() {
+		w := &worker.V9Worker{}
+		var labels []byte
+		var lastHeartbeat time.Time
+		err = rows.Scan(&w.Name, &w.URL, &labels, &lastHeartbeat)
+		if err != nil {
+			return fmt.Errorf("could not read registered worker: %w", err)
+		}
+		if len(labels) > 0 {
+			err = json.Unmarshal(labels, &w.Labels)
+			if err != nil {
+				return fmt.Errorf("could not read labels of worker %s: %w", w.Name, err)
+			}
+		}
+		if reg.static[w.Name] {
+			continue
+		}
+
+		reg.workers[w.Name] = w
+		reg.lastHeartbeats[w.Name] = lastHeartbeat
+	}
+
+	return rows.Err()
+}
+
+// Add a worker that does not register itself or send heartbeats
+func (reg *WorkerRegistry) AddStatic(w *worker.V9Worker) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	reg.workers[w.Name] = w
+	reg.static[w.Name] = true
+}
+
+// Add the worker (or update its labels, if it registered before), counting it as a heartbeat. The URL of a name
+// only changes once the worker at the old URL has stopped sending heartbeats, and static workers can't be replaced.
+func (reg *WorkerRegistry) Register(name string, url string, labels map[string]string) error {
+	if name == "" || url == "" {
+		return errors.New("workers need a name and a URL to register")
+	}
+
+	// Only the worker itself can move, once it stops sending heartbeats
+	reg.mux.Lock()
+	existing, registered := reg.workers[name]
+	taken := registered && (reg.static[name] || (existing.URL != url && !reg.isExpired(name, time.Now())))
+	reg.mux.Unlock()
+	if taken {
+		return fmt.Errorf("%s is registered at %s: %w", name, existing.URL, ErrWorkerNameTaken)
+	}
+
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	upsertQuery := `INSERT INTO v9.public.workers(worker_name, worker_url, labels, last_heartbeat)
+	VALUES ($1, $2, $3, NOW())
+	ON CONFLICT (worker_name) DO UPDATE SET worker_url = $2, labels = $3, last_heartbeat = NOW()`
+	_, err = reg.driver.db.Exec(upsertQuery, name, url, labelsJSON)
+	if err != nil {
+		return fmt.Errorf("could not register worker: %w", err)
+	}
+
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	// Replace the worker rather than changing it, since other goroutines may be using the old one
+	reg.workers[name] = &worker.V9Worker{Name: name, URL: url, Labels: labels}
+	reg.lastHeartbeats[name] = time.Now()
+
+	log.Info.Println("Registered worker", name, "at", url, "with labels", labels)
+	return nil
+}
+
+// Record that the worker is still around. Returns whether it had missed its heartbeats before this one.
+func (reg *WorkerRegistry) Heartbeat(name string) (bool, error) {
+	reg.mux.Lock()
+	_, registered := reg.workers[name]
+	wasExpired := registered && reg.isExpired(name, time.Now())
+	if registered {
+		reg.lastHeartbeats[name] = time.Now()
+	}
+	reg.mux.Unlock()
+
+	if !registered {
+		return false, fmt.Errorf("%s: %w", name, ErrUnknownWorker)
+	}
+
+	updateQuery := `UPDATE v9.public.workers SET last_heartbeat = NOW() WHERE worker_name = $1`
+	_, err := reg.driver.db.Exec(updateQuery, name)
+	if err != nil {
+		return false, fmt.Errorf("could not record heartbeat: %w", err)
+	}
+
+	if wasExpired {
+		log.Info.Println("Worker", name, "is sending heartbeats again")
+	}
+	return wasExpired, nil
+}
+
+// Forget workers that stopped sending heartbeats long ago, so they have to register again to come back
+func (reg *WorkerRegistry) Prune(now time.Time) error {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	for name := range reg.workers {
+		if reg.static[name] || now.Sub(reg.lastHeartbeats[name]) <= registrationRetention {
+			continue
+		}
+
+		// The row stays, since what ran on the worker still refers to it
+		updateQuery := `UPDATE v9.public.workers SET worker_url = NULL, labels = NULL WHERE worker_name = $1`
+		_, err := reg.driver.db.Exec(updateQuery, name)
+		if err != nil {
+			return fmt.Errorf("could not forget worker %s: %w", name, err)
+		}
+		delete(reg.workers, name)
+		delete(reg.lastHeartbeats, name)
+		log.Info.Println("Forgot worker", name, "since it has not sent a heartbeat in", registrationRetention)
+	}
+	return nil
+}
+
+// Every registered worker, sorted by name
+func (reg *WorkerRegistry) Workers() []*worker.V9Worker {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	workers := make([]*worker.V9Worker, 0, len(reg.workers))
+	for _, w := range reg.workers {
+		workers = append(workers, w)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	return workers
+}
+
+func (reg *WorkerRegistry) Find(name string) (*worker.V9Worker, bool) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	w, ok := reg.workers[name]
+	return w, ok
+}
+
+// Whether the worker stopped sending heartbeats (static workers never do)
+func (reg *WorkerRegistry) MissedHeartbeat(w *worker.V9Worker) bool {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	return reg.isExpired(w.Name, time.Now())
+}
+
+// Must be called with the mutex held
+func (reg *WorkerRegistry) isExpired(name string, now time.Time) bool {
+	if reg.static[name] {
+		return false
+	}
+	return now.Sub(reg.lastHeartbeats[name]) > reg.heartbeatTimeout
+}
@@ -1,8 +1,10 @@
 package deployment
 
 import (
-	"math/rand"
+	"context"
+	"errors"
 	"sync"
+	"time"
 	"v9_deployment_manager/activator"
 	"v9_deployment_manager/database"
 	"v9_deployment_manager/log"
@@ -12,20 +14,143 @@ import (
 const headHashSentinel = "HEAD"
 const updaterChanSize = 1024
 
+var errMissedHeartbeat = errors.New("worker stopped sending heartbeats")
+
+type ActionManagerConfig struct {
+	Placer Placer
+
+	// How many components can be reconciled at the same time
+	ReconcileConcurrency int
+
+	// How long blue/green components keep the old color running after switching, so we can flip back
+	BlueGreenRetention time.Duration
+
+	// How long canaries are observed before they are promoted or aborted
+	CanaryWindow time.Duration
+
+	// How long a new hash is watched for regressions before it is considered known-good
+	RollbackWatchWindow time.Duration
+
+	// Only log what would be done, and never touch the workers
+	DryRun bool
+
+	// How often to reconcile (and check for drift) even if nothing told us the state changed
+	ReconcileInterval time.Duration
+
+	// How long a worker can be unreachable before its components are moved to other workers
+	FailoverGracePeriod time.Duration
+
+	// How long to wait for more updates to a component before deploying the newest one (zero deploys right away)
+	DebounceWindow time.Duration
+
+	// How often to scale autoscaled components to their traffic
+	AutoscaleInterval time.Duration
+
+	// How long after scaling a component it can be scaled up, or down, again
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+}
+
 type ActionManager struct {
 	driver *database.Driver
 
 	activator *activator.Activator
-	workers   []*worker.V9Worker
+	workers   *database.WorkerRegistry
+	placer    Placer
+	dryRun    bool
 
 	pathHashMux     sync.Mutex
 	pathHashes      map[worker.ComponentPath]string
 	pathHashUpdater chan worker.ComponentID
+	// Until we start leading, hash updates are saved for the leader instead (guarded by pathHashMux)
+	started bool
+
+	// Hashes components are held on, no matter what gets pushed
+	pinMux       sync.Mutex
+	pinnedHashes map[worker.ComponentPath]string
+
+	debounceMux     sync.Mutex
+	debounceWindow  time.Duration
+	debounceTimers  map[worker.ComponentPath]*time.Timer
+	debouncedHashes map[worker.ComponentPath]string
+
+	metricsMux sync.Mutex
+	metrics    Metrics
+
+	// How often the autoscaler runs, and how long it leaves a component alone after changing its replicas
+	autoscaleInterval time.Duration
+	scaleUpCooldown   time.Duration
+	scaleDownCooldown time.Duration
+
+	// Components whose last reconciliation failed, and when to try them again
+	failureMux        sync.Mutex
+	componentFailures map[worker.ComponentPath]componentFailure
+
+	// Activations that haven't finished yet, so they can be stopped if a newer hash makes them pointless
+	buildMux       sync.Mutex
+	inFlightBuilds map[worker.ComponentPath]map[*inFlightBuild]bool
+	// How many times each component's builds were swept for a new hash, so a reconciler can tell its hash went stale
+	buildSweeps map[worker.ComponentPath]uint64
 
 	dirtyStateNotifier chan struct{}
+	reconcileInterval  time.Duration
+
+	snapshot     *ClusterSnapshot
+	workerHealth *workerHealthTracker
+
+	blueGreenMux       sync.Mutex
+	blueGreenStates    map[worker.ComponentPath]blueGreenState
+	blueGreenRetention time.Duration
+
+	canaryMux    sync.Mutex
+	canaryStates map[worker.ComponentPath]canaryState
+	canaryWindow time.Duration
+
+	rollbackMux         sync.Mutex
+	regressionWatches   map[worker.ComponentPath]regressionWatch
+	knownGoodHashes     map[worker.ComponentPath]string
+	rollbackWatchWindow time.Duration
+
+	failoverMux         sync.Mutex
+	failedOver          map[string]map[worker.ComponentID]time.Time
+	failoverGracePeriod time.Duration
+
+	// Workers nothing new is placed on, by name (draining workers are also cordoned)
+	cordonMux sync.Mutex
+	cordoned  map[string]bool
+	draining  map[string]bool
+
+	// Why components could not get all their replicas, as of the last time they were reconciled
+	unplaceableMux     sync.Mutex
+	unplaceableReasons map[worker.ComponentPath]string
+	overQuotaReasons   map[worker.ComponentPath]string
+
+	driftMux          sync.Mutex
+	driftCheckPending bool
+	reportedDrift     map[drift]bool
+
+	componentSlotMux   sync.Mutex
+	componentSlots     map[worker.ComponentPath]*componentSlot
+	reconcileSemaphore chan struct{}
+}
+
+// Makes sure only one goroutine reconciles a component at a time, and that at most one more is waiting to
+type componentSlot struct {
+	// Held for as long as the component is being reconciled
+	reconcileMux sync.Mutex
+
+	pendingMux sync.Mutex
+	// The newest state of the component waiting to be reconciled (nil if nothing is waiting)
+	pending *database.ActiveComponent
+	// How many goroutines are reconciling or waiting to reconcile the component
+	inFlight int
 }
 
-func NewActionManager(activator *activator.Activator, dr *database.Driver, workers []*worker.V9Worker) *ActionManager {
+func NewActionManager(
+	activator *activator.Activator,
+	dr *database.Driver,
+	workers *database.WorkerRegistry,
+	config ActionManagerConfig) *ActionManager {
 	pathHashes := make(map[worker.ComponentPath]string)
 
 	pathHashUpdater := make(chan worker.ComponentID, updaterChanSize)
@@ -36,26 +161,88 @@ func NewActionManager(activator *activator.Activator, dr *database.Driver, worke
 
 		activator: activator,
 		workers:   workers,
+		placer:    config.Placer,
+		dryRun:    config.DryRun,
 
 		pathHashes:      pathHashes,
 		pathHashUpdater: pathHashUpdater,
 
+		pinnedHashes: make(map[worker.ComponentPath]string),
+
+		debounceWindow:  config.DebounceWindow,
+		debounceTimers:  make(map[worker.ComponentPath]*time.Timer),
+		debouncedHashes: make(map[worker.ComponentPath]string),
+
+		metrics: newMetrics(),
+
+		autoscaleInterval: config.AutoscaleInterval,
+		scaleUpCooldown:   config.ScaleUpCooldown,
+		scaleDownCooldown: config.ScaleDownCooldown,
+
+		componentFailures: make(map[worker.ComponentPath]componentFailure),
+
+		inFlightBuilds: make(map[worker.ComponentPath]map[*inFlightBuild]bool),
+		buildSweeps:    make(map[worker.ComponentPath]uint64),
+
 		dirtyStateNotifier: dirtyStateNotifier,
+		reconcileInterval:  config.ReconcileInterval,
+
+		snapshot:     NewClusterSnapshot(),
+		workerHealth: newWorkerHealthTracker(),
+
+		blueGreenStates:    make(map[worker.ComponentPath]blueGreenState),
+		blueGreenRetention: config.BlueGreenRetention,
+
+		canaryStates: make(map[worker.ComponentPath]canaryState),
+		canaryWindow: config.CanaryWindow,
+
+		regressionWatches:   make(map[worker.ComponentPath]regressionWatch),
+		knownGoodHashes:     make(map[worker.ComponentPath]string),
+		rollbackWatchWindow: config.RollbackWatchWindow,
+
+		failedOver:          make(map[string]map[worker.ComponentID]time.Time),
+		failoverGracePeriod: config.FailoverGracePeriod,
+
+		cordoned: make(map[string]bool),
+		draining: make(map[string]bool),
+
+		unplaceableReasons: make(map[worker.ComponentPath]string),
+		overQuotaReasons:   make(map[worker.ComponentPath]string),
+
+		reportedDrift: make(map[drift]bool),
+
+		componentSlots:     make(map[worker.ComponentPath]*componentSlot),
+		reconcileSemaphore: make(chan struct{}, config.ReconcileConcurrency),
+	}
+
+	return mgr
+}
+
+// Start managing components. Only one manager (the leader) should be started at a time.
+func (mgr *ActionManager) Start() error {
+	// Updates that come in from now on are ours to apply, and the ones saved before are loaded below
+	mgr.pathHashMux.Lock()
+	mgr.started = true
+	mgr.pathHashMux.Unlock()
+
+	err := mgr.loadState()
+	if err != nil {
+		return err
 	}
 
 	go func() {
 		for {
-			updatedID := <-pathHashUpdater
+			updatedID := <-mgr.pathHashUpdater
 			path := worker.ComponentPath{
 				User: updatedID.User,
 				Repo: updatedID.Repo,
 			}
 
-			mgr.pathHashMux.Lock()
-			mgr.pathHashes[path] = updatedID.Hash
-			mgr.pathHashMux.Unlock()
+			if mgr.isRolledBack(updatedID) {
+				continue
+			}
 
-			mgr.NotifyComponentStateChanged()
+			mgr.debounceHashUpdate(path, updatedID.Hash)
 		}
 	}()
 
@@ -70,7 +257,100 @@ func NewActionManager(activator *activator.Activator, dr *database.Driver, worke
 		}
 	}()
 
-	return mgr
+	go func() {
+		// Reconcile every so often, in case something happened that nobody told us about
+		ticker := time.NewTicker(mgr.reconcileInterval)
+		for range ticker.C {
+			mgr.requestDriftCheck()
+			mgr.NotifyComponentStateChanged()
+		}
+	}()
+
+	go func() {
+		// Follow the traffic of autoscaled components
+		ticker := time.NewTicker(mgr.autoscaleInterval)
+		for now := range ticker.C {
+			err := mgr.autoscale(now)
+			if err != nil {
+				log.Error.Println("Could not autoscale components:", err)
+			}
+		}
+	}()
+
+	// State may be dirty when we start
+	mgr.NotifyComponentStateChanged()
+	return nil
+}
+
+// Everything the leader before us saved
+func (mgr *ActionManager) loadState() error {
+	err := mgr.loadDesiredHashes()
+	if err != nil {
+		return err
+	}
+	err = mgr.loadPinnedHashes()
+	if err != nil {
+		return err
+	}
+	err = mgr.loadCordonedWorkers()
+	if err != nil {
+		return err
+	}
+	err = mgr.loadBlueGreenStates()
+	if err != nil {
+		return err
+	}
+	return mgr.loadRollbackState()
+}
+
+// Log what the leader would do next. Dry runs never lead, so they go by whatever the leader saved last.
+func (mgr *ActionManager) LogPlan(now time.Time) error {
+	// Nothing is pushed to a dry run, so the saved hashes are always newer than the ones we loaded before
+	mgr.pathHashMux.Lock()
+	mgr.pathHashes = make(map[worker.ComponentPath]string)
+	mgr.pathHashMux.Unlock()
+
+	err := mgr.loadState()
+	if err != nil {
+		return err
+	}
+	err = mgr.logPlan()
+	if err != nil {
+		return err
+	}
+	return mgr.autoscale(now)
+}
+
+// Don't go back to something we rolled back
+func (mgr *ActionManager) isRolledBack(compID worker.ComponentID) bool {
+	if compID.Hash == headHashSentinel {
+		return false
+	}
+
+	isBad, err := mgr.driver.IsBadHash(compID)
+	if err != nil {
+		log.Error.Println("Could not check for bad hash:", err)
+		return false
+	}
+	if isBad {
+		log.Warning.Println("Ignoring update to", compID, "since it was rolled back before")
+	}
+	return isBad
+}
+
+// Every registered worker that is still sending heartbeats. Workers that stopped count as failing their status
+// checks, if recordMissed is set, so they are failed over just like workers that stopped answering.
+func (mgr *ActionManager) heartbeatingWorkers(recordMissed bool) []*worker.V9Worker {
+	now := time.Now()
+	heartbeating := make([]*worker.V9Worker, 0)
+	for _, w := range mgr.workers.Workers() {
+		if !mgr.workers.MissedHeartbeat(w) {
+			heartbeating = append(heartbeating, w)
+		} else if recordMissed {
+			mgr.workerHealth.recordFailure(w, errMissedHeartbeat, now)
+		}
+	}
+	return heartbeating
 }
 
 func (mgr *ActionManager) NotifyComponentStateChanged() {
@@ -82,219 +362,307 @@ func (mgr *ActionManager) NotifyComponentStateChanged() {
 }
 
 func (mgr *ActionManager) UpdateComponentHash(compID worker.ComponentID) {
+	if mgr.queueHashUpdate(compID) {
+		return
+	}
 	mgr.pathHashUpdater <- compID
 }
 
-func (mgr *ActionManager) HandleDirtyState() error {
-	// TODO: Parallelize this step (it basically single threads the deployment manager at the moment)
+// Save the update for whoever leads next (maybe us), when we don't lead yet. Returns false once we do.
+func (mgr *ActionManager) queueHashUpdate(compID worker.ComponentID) bool {
+	mgr.pathHashMux.Lock()
+	defer mgr.pathHashMux.Unlock()
 
-	// TODO: Smarter error handling
+	if mgr.started {
+		return false
+	}
+	if mgr.isRolledBack(compID) {
+		return true
+	}
+	if mgr.dryRun {
+		log.Info.Println("Dry run: not saving", compID, "for the leader")
+		return true
+	}
+
+	compPath := worker.ComponentPath{User: compID.User, Repo: compID.Repo}
+	err := mgr.driver.SetDesiredHash(compPath, compID.Hash)
+	if err != nil {
+		log.Error.Println("Could not save", compID, "for the leader:", err)
+		return true
+	}
+	log.Info.Println("Saved", compID, "for the leader, since we are not leading")
+	return true
+}
 
-	// Lock the component hashes in place
+func (mgr *ActionManager) desiredHash(compPath worker.ComponentPath) (string, bool) {
 	mgr.pathHashMux.Lock()
 	defer mgr.pathHashMux.Unlock()
 
-	log.Info.Println("Beginning dirty state handling")
+	hash, ok := mgr.pathHashes[compPath]
+	return hash, ok
+}
 
-	active, err := mgr.driver.FindActiveComponents()
+func (mgr *ActionManager) setDesiredHash(compPath worker.ComponentPath, hash string) {
+	mgr.pathHashMux.Lock()
+	previousHash, known := mgr.pathHashes[compPath]
+	mgr.pathHashes[compPath] = hash
+	mgr.persistDesiredHash(compPath, hash)
+	mgr.pathHashMux.Unlock()
+
+	// Anything still being built for the old hash would only be replaced (the same hash again changes nothing)
+	if !known || previousHash != hash {
+		mgr.cancelSupersededBuilds(compPath, hash)
+	}
+}
+
+// Must be called with the path hash mutex held, so the database sees the changes in the same order we do
+func (mgr *ActionManager) persistDesiredHash(compPath worker.ComponentPath, hash string) {
+	err := mgr.driver.SetDesiredHash(compPath, hash)
 	if err != nil {
-		return err
+		// We still know the hash, we would just forget it if we restarted now
+		log.Error.Println("Could not persist desired hash", hash, "of", compPath, ":", err)
 	}
+}
 
-	// deactivate things that should not be running anywhere
-	log.Info.Println("Deactivating non-active components")
-	for _, w := range mgr.workers {
-		err = mgr.deactivateNonactive(w, active)
-		if err != nil {
-			return err
-		}
+// Forget everything about a component that is gone for good (what it runs is cleaned up by the next pass)
+func (mgr *ActionManager) ForgetComponent(compPath worker.ComponentPath) {
+	mgr.debounceMux.Lock()
+	if timer, waiting := mgr.debounceTimers[compPath]; waiting {
+		timer.Stop()
+		delete(mgr.debounceTimers, compPath)
+		delete(mgr.debouncedHashes, compPath)
 	}
+	mgr.debounceMux.Unlock()
 
-	// start things that should be running somewhere but are not
-	log.Info.Println("Starting active but not running components")
-	for _, activeComp := range active {
-		var hashToDeploy = headHashSentinel
-		if mapHash, ok := mgr.pathHashes[activeComp]; ok {
-			hashToDeploy = mapHash
-		}
+	// No hash of it is wanted anymore, so nothing it is building is either
+	mgr.cancelBuildsOtherThan(compPath, "")
 
-		err = mgr.activateMissing(worker.ComponentID{
-			User: activeComp.User,
-			Repo: activeComp.Repo,
-			Hash: hashToDeploy,
-		})
-		if err != nil {
-			return err
-		}
-	}
+	mgr.pathHashMux.Lock()
+	delete(mgr.pathHashes, compPath)
+	mgr.persistDesiredHash(compPath, "")
+	mgr.pathHashMux.Unlock()
 
-	// ensure that, for each component, there is a worker running the latest version
-	log.Info.Println("Ensuring that every component has the latest version running somewhere")
-	for _, activeComp := range active {
-		// We only need to make sure things are up to date when we know what's supposed to be running
-		if correctHash, ok := mgr.pathHashes[activeComp]; ok {
-			correctCompID := worker.ComponentID{
-				User: activeComp.User,
-				Repo: activeComp.Repo,
-				Hash: correctHash,
-			}
-			err = mgr.ensureSomeWorkerIsRunning(correctCompID)
-			if err != nil {
-				return err
-			}
-		}
+	mgr.blueGreenMux.Lock()
+	delete(mgr.blueGreenStates, compPath)
+	err := mgr.driver.DeleteBlueGreenState(compPath)
+	if err != nil {
+		log.Error.Println("Could not forget blue/green state of", compPath, ":", err)
 	}
+	mgr.blueGreenMux.Unlock()
 
-	// deactivate workers running old hashes of components
-	log.Info.Println("Deactivating old hashes wherever they are")
-	for _, activeComp := range active {
-		correctHash, ok := mgr.pathHashes[activeComp]
-		// If we couldn't grab the correct hash, whatever -- assume we're chugging along fine
-		if !ok {
-			continue
-		}
+	mgr.clearCanaryState(compPath)
 
-		correctCompID := worker.ComponentID{
-			User: activeComp.User,
-			Repo: activeComp.Repo,
-			Hash: correctHash,
-		}
-		for _, w := range mgr.workers {
-			err = mgr.deactivateIfHashDiffers(w, correctCompID)
-			if err != nil {
-				return err
-			}
+	mgr.pinMux.Lock()
+	if _, pinned := mgr.pinnedHashes[compPath]; pinned {
+		err := mgr.driver.SetPinnedHash(compPath, "")
+		if err != nil {
+			log.Error.Println("Could not unpin", compPath, ":", err)
 		}
+		delete(mgr.pinnedHashes, compPath)
+	}
+	mgr.pinMux.Unlock()
+
+	mgr.rollbackMux.Lock()
+	delete(mgr.regressionWatches, compPath)
+	delete(mgr.knownGoodHashes, compPath)
+	err = mgr.driver.DeleteRegressionWatch(compPath)
+	if err == nil {
+		err = mgr.driver.SetKnownGoodHash(compPath, "")
+	}
+	if err != nil {
+		log.Error.Println("Could not forget rollback state of", compPath, ":", err)
 	}
+	mgr.rollbackMux.Unlock()
 
-	log.Info.Println("Finished dirty state handling")
-	return nil
+	mgr.failureMux.Lock()
+	delete(mgr.componentFailures, compPath)
+	mgr.failureMux.Unlock()
+
+	mgr.NotifyComponentStateChanged()
 }
 
-func (mgr *ActionManager) deactivateNonactive(w *worker.V9Worker, active []worker.ComponentPath) error {
-	status, err := w.Status()
+// Pick up the desired hashes from before we (or the previous leader) stopped
+func (mgr *ActionManager) loadDesiredHashes() error {
+	hashes, err := mgr.driver.FindDesiredHashes()
 	if err != nil {
 		return err
 	}
 
-	nonActive := status.FindNonactive(active)
-	for _, incorrectlyRunning := range nonActive {
-		log.Info.Println("Deactivating incorrectly running", incorrectlyRunning, "on worker", w.URL)
+	mgr.pathHashMux.Lock()
+	defer mgr.pathHashMux.Unlock()
 
-		err := mgr.activator.Deactivate(incorrectlyRunning, w)
-		if err != nil {
-			return err
+	for compPath, hash := range hashes {
+		// Anything that came in before we started is newer
+		if _, ok := mgr.pathHashes[compPath]; !ok {
+			mgr.pathHashes[compPath] = hash
 		}
 	}
-
+	log.Info.Println("Loaded", len(hashes), "desired hash(es)")
 	return nil
 }
 
-func (mgr *ActionManager) activateMissing(toCheck worker.ComponentID) error {
-	path := worker.ComponentPath{
-		User: toCheck.User,
-		Repo: toCheck.Repo,
+// Like setDesiredHash, but leaves the hash alone if someone pushed a concrete hash in the meantime
+func (mgr *ActionManager) resolveDesiredHash(compPath worker.ComponentPath, hash string) {
+	mgr.pathHashMux.Lock()
+	defer mgr.pathHashMux.Unlock()
+
+	if current, ok := mgr.pathHashes[compPath]; !ok || current == headHashSentinel {
+		mgr.pathHashes[compPath] = hash
+		mgr.persistDesiredHash(compPath, hash)
 	}
+}
 
-	for _, w := range mgr.workers {
-		status, err := w.Status()
-		if err != nil {
-			return err
-		}
+func (mgr *ActionManager) slotFor(compPath worker.ComponentPath) *componentSlot {
+	mgr.componentSlotMux.Lock()
+	defer mgr.componentSlotMux.Unlock()
 
-		// If it is running somewhere we are good
-		if status.ContainsPath(path) {
-			return nil
-		}
+	slot, ok := mgr.componentSlots[compPath]
+	if !ok {
+		slot = &componentSlot{}
+		mgr.componentSlots[compPath] = slot
 	}
+	return slot
+}
 
-	// Otherwise pick a worker randomly and deploy there
-	randomWorker := mgr.workers[rand.Intn(len(mgr.workers))]
-	log.Info.Println("Activating missing", toCheck, "on worker", randomWorker)
-	activatedHash, err := mgr.activator.Activate(toCheck, randomWorker)
-	if err != nil {
-		return err
+// Reconciles the component in its own goroutine, without waiting for it to finish
+func (mgr *ActionManager) scheduleReconcile(comp database.ActiveComponent) {
+	slot := mgr.slotFor(comp.Path)
+
+	slot.pendingMux.Lock()
+	alreadyWaiting := slot.pending != nil
+	slot.pending = &comp
+	if !alreadyWaiting {
+		slot.inFlight++
 	}
+	slot.pendingMux.Unlock()
 
-	// Update the relevant hash (if we're using HEAD) so the map will match in the update step
-	if toCheck.Hash == headHashSentinel {
-		mgr.pathHashes[worker.ComponentPath{
-			User: toCheck.User,
-			Repo: toCheck.Repo,
-		}] = activatedHash
+	// Someone is already waiting to reconcile this component, and they will pick up the state we just left
+	if alreadyWaiting {
+		return
 	}
 
-	return nil
+	go func() {
+		defer func() {
+			slot.pendingMux.Lock()
+			slot.inFlight--
+			slot.pendingMux.Unlock()
+		}()
+
+		slot.reconcileMux.Lock()
+		defer slot.reconcileMux.Unlock()
+
+		slot.pendingMux.Lock()
+		toReconcile := *slot.pending
+		slot.pending = nil
+		slot.pendingMux.Unlock()
+
+		mgr.reconcileSemaphore <- struct{}{}
+		defer func() { <-mgr.reconcileSemaphore }()
+
+		err := mgr.newReconciler().reconcileComponent(toReconcile)
+		if errors.Is(err, context.Canceled) {
+			// Builds are only cancelled once the component wants something else, so go again with that
+			log.Info.Println("Stopped reconciling", toReconcile.Path, "since it wants a different hash now")
+			mgr.NotifyComponentStateChanged()
+		} else if err != nil {
+			mgr.recordComponentFailure(toReconcile.Path, err, time.Now())
+		} else {
+			mgr.recordComponentSuccess(toReconcile.Path)
+		}
+	}()
 }
 
-func (mgr *ActionManager) ensureSomeWorkerIsRunning(compID worker.ComponentID) error {
-	compPath := worker.ComponentPath{
-		User: compID.User,
-		Repo: compID.Repo,
+// Whether the component is being reconciled right now (or is about to be)
+func (mgr *ActionManager) isReconciling(compPath worker.ComponentPath) bool {
+	slot := mgr.slotFor(compPath)
+
+	slot.pendingMux.Lock()
+	defer slot.pendingMux.Unlock()
+
+	return slot.inFlight > 0
+}
+
+// Errors that only concern one component (or one worker) are logged, and the rest of the pass goes on without it.
+func (mgr *ActionManager) HandleDirtyState() error {
+	if mgr.dryRun {
+		return mgr.logPlan()
 	}
 
-	notRunningAnyVersion := make([]*worker.V9Worker, 0)
+	log.Info.Println("Beginning dirty state handling")
 
-	for _, w := range mgr.workers {
-		status, err := w.Status()
-		if err != nil {
-			return err
-		}
+	active, unreadable, err := mgr.driver.FindActiveComponents()
+	if err != nil {
+		return err
+	}
 
-		for _, runningComp := range status.ActiveComponents {
-			// If we find someone running exactly this ID, we have ensured some worker is running this ID
-			if runningComp.ID == compID {
-				return nil
-			}
-		}
+	// workers that stopped sending heartbeats long ago have to register again
+	err = mgr.workers.Prune(time.Now())
+	if err != nil {
+		log.Error.Println("Could not forget expired workers:", err)
+	}
 
-		if !status.ContainsPath(compPath) {
-			notRunningAnyVersion = append(notRunningAnyVersion, w)
-		}
+	// find out what every worker is running, once for the whole pass (leaving out workers that don't answer)
+	mgr.snapshot.Refresh(mgr.heartbeatingWorkers(true), mgr.workerHealth)
+	if nextProbe, ok := mgr.workerHealth.nextProbe(); ok {
+		time.AfterFunc(time.Until(nextProbe), mgr.NotifyComponentStateChanged)
 	}
 
-	// If we get here we need to deploy to some worker
-	var workerToDeployTo *worker.V9Worker
-	if len(notRunningAnyVersion) > 0 {
-		workerToDeployTo = notRunningAnyVersion[rand.Intn(len(notRunningAnyVersion))]
-	} else {
-		// If everyone is running it, then we need to create a place to deploy to
-		workerToDeployTo = mgr.workers[rand.Intn(len(mgr.workers))]
-		err := mgr.activator.Deactivate(compID, workerToDeployTo)
-		if err != nil {
-			return err
+	// anything over its user's quota is treated like it is not active (or runs fewer replicas)
+	active, overQuota, unchecked := mgr.enforceQuotas(active, mgr.snapshot)
+	mgr.recordOverQuota(overQuota)
+	activePaths := make([]worker.ComponentPath, len(active))
+	for i, activeComp := range active {
+		activePaths[i] = activeComp.Path
+	}
+
+	// components we can't make sense of are left running whatever they run, and count as failing until we can
+	now := time.Now()
+	for _, skipped := range append(unreadable, unchecked...) {
+		activePaths = append(activePaths, skipped.Path)
+		if mgr.shouldRetry(skipped.Path, now) {
+			mgr.recordComponentFailure(skipped.Path, skipped.Err, now)
 		}
 	}
 
-	log.Info.Println("Doing to deploy to ensure", compID, "is on some worker", workerToDeployTo)
-	deployedHash, err := mgr.activator.Activate(compID, workerToDeployTo)
+	// hold on to what unreachable workers were running for a while, then move it somewhere else
+	held, failovers, err := mgr.findUnreachableComponents(active)
 	if err != nil {
 		return err
 	}
-
-	// Update the hash we're storing if we had HEAD
-	if compID.Hash == headHashSentinel {
-		mgr.pathHashes[compPath] = deployedHash
+	mgr.snapshot.SetHeld(held)
+	mgr.notifyAfterGracePeriods()
+	err = mgr.recordFailovers(failovers)
+	if err != nil {
+		// The failovers still happen, they just aren't in the event log
+		log.Error.Println("Could not record failovers:", err)
 	}
 
-	return nil
-}
+	// on periodic passes, look for anything that drifted away from what we set up
+	if mgr.takeDriftCheck() {
+		err = mgr.recordDrift(mgr.detectDrift(active))
+		if err != nil {
+			log.Error.Println("Could not record drift:", err)
+		}
+	}
 
-func (mgr *ActionManager) deactivateIfHashDiffers(w *worker.V9Worker, compID worker.ComponentID) error {
-	status, err := w.Status()
+	// deactivate things that should not be running anywhere
+	log.Info.Println("Deactivating non-active components")
+	err = mgr.newReconciler().deactivateAllNonactive(activePaths)
 	if err != nil {
-		return err
+		// They are tried again on the next pass
+		log.Error.Println("Could not deactivate every non-active component:", err)
 	}
 
-	for _, runningComp := range status.ActiveComponents {
-		if runningComp.ID.User == compID.User && runningComp.ID.Repo == compID.Repo && runningComp.ID.Hash != compID.Hash {
-			log.Info.Println("Doing to deactivate to ensure", w.URL, "does not keep running", compID)
-			err = mgr.activator.Deactivate(runningComp.ID, w)
-			if err != nil {
-				return err
-			}
+	// reconcile every active component on its own, so one slow (or broken) build doesn't hold up the rest
+	log.Info.Println("Scheduling reconciliation of active components")
+	for _, activeComp := range active {
+		if !mgr.shouldRetry(activeComp.Path, now) {
+			log.Info.Println("Not reconciling", activeComp.Path, "until its retry backoff is over")
+			continue
 		}
+		mgr.scheduleReconcile(activeComp)
 	}
 
+	log.Info.Println("Finished dirty state handling")
 	return nil
 }
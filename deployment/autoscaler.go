@@ -0,0 +1,161 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// How far back to look at stats when working out how busy a component is
+const autoscaleStatsWindow = 2 * time.Minute
+
+// Scale every autoscaled component to its traffic. One component failing to scale doesn't stop the rest.
+func (mgr *ActionManager) autoscale(now time.Time) error {
+	autoscaled, err := mgr.driver.FindAutoscaledComponents()
+	if err != nil {
+		return err
+	}
+
+	for _, comp := range autoscaled {
+		err = mgr.autoscaleComponent(comp, now)
+		if err != nil {
+			log.Error.Println("Could not autoscale", comp.Path, ":", err)
+		}
+	}
+	return nil
+}
+
+// Scale the component to its traffic now, rather than on the next tick (so a new range applies right away)
+func (mgr *ActionManager) AutoscaleComponent(compPath worker.ComponentPath) error {
+	autoscaled, err := mgr.driver.FindAutoscaledComponents()
+	if err != nil {
+		return err
+	}
+
+	for _, comp := range autoscaled {
+		if comp.Path == compPath {
+			return mgr.autoscaleComponent(comp, time.Now())
+		}
+	}
+	return nil
+}
+
+func (mgr *ActionManager) autoscaleComponent(comp database.AutoscaledComponent, now time.Time) error {
+	requestsPerSecond, samples, err := mgr.driver.FindRequestRate(comp.Path, now.Add(-autoscaleStatsWindow))
+	if err != nil {
+		return err
+	}
+
+	decision, scale := mgr.decideScaling(comp, requestsPerSecond, samples, now)
+	if !scale {
+		return nil
+	}
+
+	if mgr.dryRun {
+		log.Info.Println("Would scale", comp.Path, "from", decision.FromReplicas, "to", decision.ToReplicas, "--",
+			decision.Reason)
+		return nil
+	}
+
+	if decision.ToReplicas > decision.FromReplicas {
+		err = mgr.driver.CheckReplicasQuota(comp.Path, decision.ToReplicas)
+		var quotaErr *database.QuotaError
+		if errors.As(err, &quotaErr) {
+			log.Warning.Println("Not scaling", comp.Path, "up to", decision.ToReplicas, "--", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mgr.driver.SetAutoscaledReplicas(comp.Path, decision.ToReplicas, now)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Println("Scaled", comp.Path, "from", decision.FromReplicas, "to", decision.ToReplicas, "--", decision.Reason)
+	mgr.NotifyComponentStateChanged()
+
+	err = mgr.driver.InsertScalingDecision(decision)
+	if err != nil {
+		// It was still scaled, there just won't be a record of why
+		return fmt.Errorf("could not record scaling decision: %w", err)
+	}
+	return nil
+}
+
+// Work out how many replicas the component should have, given how busy it is. Returns false if it should stay as it
+// is, because it already has the right number, there are no stats to go on, or it was scaled too recently.
+func (mgr *ActionManager) decideScaling(
+	comp database.AutoscaledComponent,
+	requestsPerSecond float64,
+	samples int,
+	now time.Time) (database.ScalingDecision, bool) {
+	config := comp.Config
+	minReplicas := config.MinReplicas
+	if minReplicas < 1 {
+		minReplicas = 1
+	}
+	maxReplicas := config.MaxReplicas
+	if maxReplicas < minReplicas {
+		maxReplicas = minReplicas
+	}
+
+	decision := database.ScalingDecision{
+		ID:                      comp.Path,
+		FromReplicas:            comp.Replicas,
+		RequestsPerSecond:       requestsPerSecond,
+		TargetRequestsPerSecond: config.TargetRequestsPerSecond,
+		Samples:                 samples,
+		DecisionTime:            now,
+	}
+
+	// The range always wins, no matter how recently we scaled
+	switch {
+	case comp.Replicas < minReplicas:
+		decision.ToReplicas = minReplicas
+		decision.Reason = fmt.Sprintf("below the minimum of %d replica(s)", minReplicas)
+		return decision, true
+	case comp.Replicas > maxReplicas:
+		decision.ToReplicas = maxReplicas
+		decision.Reason = fmt.Sprintf("above the maximum of %d replica(s)", maxReplicas)
+		return decision, true
+	case samples == 0 || config.TargetRequestsPerSecond <= 0:
+		return decision, false
+	}
+
+	wanted := int(math.Ceil(requestsPerSecond / config.TargetRequestsPerSecond))
+	if wanted < minReplicas {
+		wanted = minReplicas
+	}
+	if wanted > maxReplicas {
+		wanted = maxReplicas
+	}
+	decision.ToReplicas = wanted
+
+	sinceLastScaled := now.Sub(comp.LastScaled)
+	switch {
+	case wanted > comp.Replicas:
+		if sinceLastScaled < mgr.scaleUpCooldown {
+			log.Info.Println("Not scaling", comp.Path, "up yet, it was scaled", sinceLastScaled, "ago")
+			return decision, false
+		}
+		decision.Reason = fmt.Sprintf("%.2f requests/second is more than %d replica(s) handle at %.2f each",
+			requestsPerSecond, comp.Replicas, config.TargetRequestsPerSecond)
+	case wanted < comp.Replicas:
+		if sinceLastScaled < mgr.scaleDownCooldown {
+			log.Info.Println("Not scaling", comp.Path, "down yet, it was scaled", sinceLastScaled, "ago")
+			return decision, false
+		}
+		decision.Reason = fmt.Sprintf("%.2f requests/second only needs %d replica(s) at %.2f each",
+			requestsPerSecond, wanted, config.TargetRequestsPerSecond)
+	default:
+		return decision, false
+	}
+	return decision, true
+}
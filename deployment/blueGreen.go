@@ -0,0 +1,255 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+const (
+	blueColor  = "blue"
+	greenColor = "green"
+)
+
+// How long to wait before checking again whether a new color reports healthy
+const blueGreenHealthCheckInterval = 10 * time.Second
+
+type blueGreenState struct {
+	liveHash  string
+	liveColor string
+
+	// The hash that was live before the last switch, kept running until retainUntil so we can flip back to it
+	previousHash  string
+	previousColor string
+	retainUntil   time.Time
+}
+
+func otherColor(color string) string {
+	if color == blueColor {
+		return greenColor
+	}
+	return blueColor
+}
+
+func (state blueGreenState) retainsPrevious(now time.Time) bool {
+	return state.previousHash != "" && now.Before(state.retainUntil)
+}
+
+func (mgr *ActionManager) getBlueGreenState(compPath worker.ComponentPath) (blueGreenState, bool) {
+	mgr.blueGreenMux.Lock()
+	defer mgr.blueGreenMux.Unlock()
+
+	state, ok := mgr.blueGreenStates[compPath]
+	return state, ok
+}
+
+func (mgr *ActionManager) setBlueGreenState(compPath worker.ComponentPath, state blueGreenState) {
+	mgr.blueGreenMux.Lock()
+	defer mgr.blueGreenMux.Unlock()
+
+	mgr.blueGreenStates[compPath] = state
+	err := mgr.driver.SetBlueGreenState(compPath, database.BlueGreenState{
+		LiveHash:      state.liveHash,
+		LiveColor:     state.liveColor,
+		PreviousHash:  state.previousHash,
+		PreviousColor: state.previousColor,
+		RetainUntil:   state.retainUntil,
+	})
+	if err != nil {
+		// We still know which color is live, we would just forget it if we restarted now
+		log.Error.Println("Could not persist blue/green state of", compPath, ":", err)
+	}
+}
+
+func (mgr *ActionManager) loadBlueGreenStates() error {
+	states, err := mgr.driver.FindBlueGreenStates()
+	if err != nil {
+		return err
+	}
+
+	mgr.blueGreenMux.Lock()
+	defer mgr.blueGreenMux.Unlock()
+
+	mgr.blueGreenStates = make(map[worker.ComponentPath]blueGreenState, len(states))
+	for compPath, state := range states {
+		mgr.blueGreenStates[compPath] = blueGreenState{
+			liveHash:      state.LiveHash,
+			liveColor:     state.LiveColor,
+			previousHash:  state.PreviousHash,
+			previousColor: state.PreviousColor,
+			retainUntil:   state.RetainUntil,
+		}
+	}
+	log.Info.Println("Loaded", len(states), "blue/green state(s)")
+	return nil
+}
+
+// Tell every worker running the component which color gets the traffic. Workers that just got the component
+// need to hear it too, so this happens on every pass rather than only when switching.
+func (r *reconciler) announceLiveColor(compPath worker.ComponentPath, color string) error {
+	if r.planning {
+		return nil
+	}
+
+	for _, candidate := range r.snapshot.Candidates() {
+		if !candidate.Status.ContainsPath(compPath) {
+			continue
+		}
+		err := candidate.Worker.SetLiveColor(compPath, color)
+		if err != nil {
+			return fmt.Errorf("could not set the live color of %v on %s: %w", compPath, candidate.Worker.Name, err)
+		}
+	}
+	return nil
+}
+
+// Go back to the previously live color of a blue/green component, as long as it is still being kept around
+func (mgr *ActionManager) FlipBlueGreen(compPath worker.ComponentPath) error {
+	if pinnedHash, pinned := mgr.pinnedHash(compPath); pinned {
+		return fmt.Errorf("%v is pinned to %s", compPath, pinnedHash)
+	}
+	state, ok := mgr.getBlueGreenState(compPath)
+	if !ok || !state.retainsPrevious(time.Now()) {
+		return fmt.Errorf("%v has no previous color to flip back to", compPath)
+	}
+
+	log.Info.Println("Flipping", compPath, "back to", state.previousColor, state.previousHash)
+	// Flipping is meant to be instant, so it doesn't wait out the debounce window like pushes do
+	mgr.applyHashUpdate(compPath, state.previousHash)
+	return nil
+}
+
+// The color the workers report for the component, if any of them do
+func findReportedColor(statuses []PlacementCandidate, compID worker.ComponentID) (string, bool) {
+	for _, candidate := range statuses {
+		for _, runningComp := range candidate.Status.ActiveComponents {
+			if runningComp.ID == compID && runningComp.Color != "" {
+				return runningComp.Color, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Whether every worker running the component reports it with the given color. The snapshot only has colors
+// for what the workers actually reported, so this stays false until the workers have picked the component up.
+func reportsHealthy(statuses []PlacementCandidate, compID worker.ComponentID, color string) bool {
+	running := 0
+	for _, candidate := range statuses {
+		for _, runningComp := range candidate.Status.ActiveComponents {
+			if runningComp.ID != compID {
+				continue
+			}
+			if runningComp.Color != color {
+				return false
+			}
+			running++
+		}
+	}
+	return running > 0
+}
+
+// Bring new hashes up as the inactive color next to the live one, and only switch once they report healthy
+func (r *reconciler) reconcileBlueGreen(comp database.ActiveComponent) error {
+	compID := r.findCorrectCompID(comp.Path)
+	now := time.Now()
+
+	state, ok := r.mgr.getBlueGreenState(comp.Path)
+	if !ok {
+		runningHash, isRunning := findRunningHash(r.snapshot.Candidates(), comp.Path)
+		if !isRunning {
+			// Nothing is live yet, so there is nothing to keep running next to the new hash
+			liveID, err := r.convergeReplicas(comp, compID, blueColor, nil)
+			if err != nil {
+				return err
+			}
+			if liveID.Hash == headHashSentinel {
+				return nil
+			}
+			r.setBlueGreenState(comp.Path, blueGreenState{liveHash: liveID.Hash, liveColor: blueColor})
+			err = r.deactivateOtherHashes(liveID, nil)
+			if err != nil {
+				return err
+			}
+			return r.announceLiveColor(comp.Path, blueColor)
+		}
+
+		// Treat whatever is already running as live
+		state = blueGreenState{liveHash: runningHash, liveColor: blueColor}
+		liveID := worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo, Hash: runningHash}
+		if color, found := findReportedColor(r.snapshot.Candidates(), liveID); found {
+			state.liveColor = color
+		}
+		r.setBlueGreenState(comp.Path, state)
+	}
+
+	// Flipping back to the previous hash is instant, since it is still running
+	if compID.Hash == state.previousHash && state.retainsPrevious(now) {
+		log.Info.Println("Switching", comp.Path, "back from", state.liveColor, state.liveHash,
+			"to", state.previousColor, state.previousHash)
+		state = blueGreenState{
+			liveHash:      state.previousHash,
+			liveColor:     state.previousColor,
+			previousHash:  state.liveHash,
+			previousColor: state.liveColor,
+			retainUntil:   now.Add(r.mgr.blueGreenRetention),
+		}
+		r.setBlueGreenState(comp.Path, state)
+		r.notifyAfter(r.mgr.blueGreenRetention)
+	}
+
+	if compID.Hash != state.liveHash {
+		// Bring the new hash up as the inactive color, next to the live one
+		newColor := otherColor(state.liveColor)
+		newID, err := r.convergeReplicas(comp, compID, newColor, []string{state.liveHash})
+		if err != nil {
+			return err
+		}
+		// There are only two colors, so the previous hash (if we still had it) has to go
+		err = r.deactivateOtherHashes(newID, []string{state.liveHash})
+		if err != nil {
+			return err
+		}
+
+		if !reportsHealthy(r.snapshot.Candidates(), newID, newColor) {
+			log.Info.Println("Waiting for", newID, "to report healthy as", newColor, "before switching")
+			r.notifyAfter(blueGreenHealthCheckInterval)
+			return nil
+		}
+
+		log.Info.Println("Switching", comp.Path, "from", state.liveColor, state.liveHash, "to", newColor, newID.Hash)
+		state = blueGreenState{
+			liveHash:      newID.Hash,
+			liveColor:     newColor,
+			previousHash:  state.liveHash,
+			previousColor: state.liveColor,
+			retainUntil:   now.Add(r.mgr.blueGreenRetention),
+		}
+		r.setBlueGreenState(comp.Path, state)
+		r.notifyAfter(r.mgr.blueGreenRetention)
+	}
+
+	// Keep the previous color around until its time is up
+	var keep []string
+	if state.retainsPrevious(now) {
+		keep = []string{state.previousHash}
+	} else if state.previousHash != "" {
+		log.Info.Println("No longer keeping", state.previousColor, state.previousHash, "of", comp.Path, "around")
+		state.previousHash = ""
+		state.previousColor = ""
+		r.setBlueGreenState(comp.Path, state)
+	}
+
+	liveID := worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo, Hash: state.liveHash}
+	_, err := r.convergeReplicas(comp, liveID, state.liveColor, keep)
+	if err != nil {
+		return err
+	}
+	err = r.deactivateOtherHashes(liveID, keep)
+	if err != nil {
+		return err
+	}
+	return r.announceLiveColor(comp.Path, state.liveColor)
+}
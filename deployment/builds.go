@@ -0,0 +1,73 @@
+package deployment
+
+import (
+	"context"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// An activation that is still cloning, building or copying, so it can be stopped once nobody wants its hash anymore
+type inFlightBuild struct {
+	hash      string
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// Keep track of the activation of compID until the returned func is called. The activation should stop when the
+// returned context is cancelled.
+func (mgr *ActionManager) startBuild(compID worker.ComponentID) (context.Context, func()) {
+	compPath := worker.ComponentPath{User: compID.User, Repo: compID.Repo}
+	ctx, cancel := context.WithCancel(context.Background())
+	build := &inFlightBuild{hash: compID.Hash, cancel: cancel}
+
+	mgr.buildMux.Lock()
+	if mgr.inFlightBuilds[compPath] == nil {
+		mgr.inFlightBuilds[compPath] = make(map[*inFlightBuild]bool)
+	}
+	mgr.inFlightBuilds[compPath][build] = true
+	mgr.buildMux.Unlock()
+
+	return ctx, func() {
+		mgr.buildMux.Lock()
+		delete(mgr.inFlightBuilds[compPath], build)
+		if len(mgr.inFlightBuilds[compPath]) == 0 {
+			delete(mgr.inFlightBuilds, compPath)
+		}
+		mgr.buildMux.Unlock()
+		cancel()
+	}
+}
+
+// Changes whenever the component wants a different hash. Builds started after reading the hash it wanted
+// have to check this once they are registered, since they missed being cancelled if it changed in between.
+func (mgr *ActionManager) buildSweep(compPath worker.ComponentPath) uint64 {
+	mgr.buildMux.Lock()
+	defer mgr.buildMux.Unlock()
+
+	return mgr.buildSweeps[compPath]
+}
+
+// Stop the component's builds of anything but hash, which it is about to be running instead.
+// Pinned components keep running their pin, so only builds of something else are stopped.
+func (mgr *ActionManager) cancelSupersededBuilds(compPath worker.ComponentPath, hash string) {
+	if pinnedHash, pinned := mgr.pinnedHash(compPath); pinned {
+		hash = pinnedHash
+	}
+	mgr.cancelBuildsOtherThan(compPath, hash)
+}
+
+func (mgr *ActionManager) cancelBuildsOtherThan(compPath worker.ComponentPath, hash string) {
+	mgr.buildMux.Lock()
+	defer mgr.buildMux.Unlock()
+
+	mgr.buildSweeps[compPath]++
+	for build := range mgr.inFlightBuilds[compPath] {
+		if build.hash == hash || build.cancelled {
+			continue
+		}
+		log.Info.Println("Cancelling build of", build.hash, "of", compPath, "in favor of", hash)
+		build.cancel()
+		build.cancelled = true
+		mgr.recordCancelledBuild(compPath)
+	}
+}
@@ -0,0 +1,136 @@
+package deployment
+
+import (
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+type canaryState struct {
+	baselineHash string
+	canaryHash   string
+	startedAt    time.Time
+}
+
+func (mgr *ActionManager) getCanaryState(compPath worker.ComponentPath) (canaryState, bool) {
+	mgr.canaryMux.Lock()
+	defer mgr.canaryMux.Unlock()
+
+	state, ok := mgr.canaryStates[compPath]
+	return state, ok
+}
+
+func (mgr *ActionManager) setCanaryState(compPath worker.ComponentPath, state canaryState) {
+	mgr.canaryMux.Lock()
+	defer mgr.canaryMux.Unlock()
+
+	mgr.canaryStates[compPath] = state
+}
+
+func (mgr *ActionManager) clearCanaryState(compPath worker.ComponentPath) {
+	mgr.canaryMux.Lock()
+	defer mgr.canaryMux.Unlock()
+
+	delete(mgr.canaryStates, compPath)
+}
+
+// A hash of the component, other than compID, that some worker is running
+func findOtherRunningHash(statuses []PlacementCandidate, compID worker.ComponentID) (string, bool) {
+	for _, candidate := range statuses {
+		for _, runningComp := range candidate.Status.ActiveComponents {
+			if runningComp.ID.User == compID.User && runningComp.ID.Repo == compID.Repo && runningComp.ID.Hash != compID.Hash {
+				return runningComp.ID.Hash, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Run new hashes on a single worker first, and only roll them out everywhere if they do as well as the old hash
+func (r *reconciler) reconcileCanary(comp database.ActiveComponent) error {
+	compID := r.findCorrectCompID(comp.Path)
+	now := time.Now()
+
+	// A canary only ever runs on one worker
+	canaryComp := comp
+	canaryComp.Replicas = 1
+
+	state, ok := r.mgr.getCanaryState(comp.Path)
+	if ok && compID.Hash != state.canaryHash && compID.Hash != state.baselineHash {
+		// Something newer came in, so this canary is pointless
+		log.Info.Println("Dropping canary", state.canaryHash, "of", comp.Path, "in favor of", compID.Hash)
+		r.clearCanaryState(comp.Path)
+		ok = false
+	}
+
+	if !ok {
+		baselineHash, isRunning := findOtherRunningHash(r.snapshot.Candidates(), compID)
+		if !isRunning {
+			// Nothing to compare a canary against
+			return r.reconcileReplace(comp)
+		}
+
+		canaryID, err := r.convergeReplicas(canaryComp, compID, "", []string{baselineHash})
+		if err != nil {
+			return err
+		}
+		if canaryID.Hash == baselineHash || canaryID.Hash == headHashSentinel {
+			return r.reconcileReplace(comp)
+		}
+
+		log.Info.Println("Started canary", canaryID.Hash, "of", comp.Path, "against", baselineHash,
+			"-- deciding in", r.mgr.canaryWindow)
+		state = canaryState{
+			baselineHash: baselineHash,
+			canaryHash:   canaryID.Hash,
+			startedAt:    now,
+		}
+		r.setCanaryState(comp.Path, state)
+		r.notifyAfter(r.mgr.canaryWindow)
+	}
+
+	baselineID := worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo, Hash: state.baselineHash}
+	canaryID := worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo, Hash: state.canaryHash}
+
+	// Someone asked for the baseline again, so give up on the canary
+	if compID.Hash == state.baselineHash {
+		log.Info.Println("Aborting canary", state.canaryHash, "of", comp.Path, "since the baseline is wanted again")
+		r.clearCanaryState(comp.Path)
+		return r.reconcileReplace(comp)
+	}
+
+	// Keep observing until the window is over
+	if now.Before(state.startedAt.Add(r.mgr.canaryWindow)) {
+		_, err := r.convergeReplicas(comp, baselineID, "", []string{state.canaryHash})
+		if err != nil {
+			return err
+		}
+		_, err = r.convergeReplicas(canaryComp, canaryID, "", []string{state.baselineHash})
+		if err != nil {
+			return err
+		}
+		return r.deactivateOtherHashes(canaryID, []string{state.baselineHash})
+	}
+
+	baselineHealth, err := r.mgr.driver.FindHashHealth(comp.Path, state.baselineHash, state.startedAt, now)
+	if err != nil {
+		return err
+	}
+	canaryHealth, err := r.mgr.driver.FindHashHealth(comp.Path, state.canaryHash, state.startedAt, now)
+	if err != nil {
+		return err
+	}
+
+	r.clearCanaryState(comp.Path)
+	if regression, regressed := findRegression(baselineHealth, canaryHealth); regressed {
+		log.Warning.Println("Aborting canary", state.canaryHash, "of", comp.Path, "--", regression)
+		r.setDesiredHash(comp.Path, state.baselineHash)
+	} else {
+		log.Info.Println("Promoting canary", state.canaryHash, "of", comp.Path,
+			"-- latency", canaryHealth.AvgMsLatency, "ms vs", baselineHealth.AvgMsLatency,
+			"ms, error rate", canaryHealth.ErrorRate(), "vs", baselineHealth.ErrorRate())
+	}
+
+	return r.reconcileReplace(comp)
+}
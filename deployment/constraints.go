@@ -0,0 +1,102 @@
+package deployment
+
+import (
+	"fmt"
+	"strings"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/worker"
+)
+
+// A component that could not be given all the replicas it wants, and why
+type UnplaceableComponent struct {
+	ID     worker.ComponentID `json:"id"`
+	Reason string             `json:"reason"`
+}
+
+// Why the worker can't run another replica of the component (empty if it can).
+// `placed` are the workers already running a replica.
+func violatesConstraints(comp database.ActiveComponent, w *worker.V9Worker, placed []PlacementCandidate) string {
+	for key, value := range comp.RequiredLabels {
+		if w.Labels[key] != value {
+			return fmt.Sprintf("%s does not have label %s=%s", w.Name, key, value)
+		}
+	}
+
+	if comp.AntiAffinity == "" {
+		return ""
+	}
+	value, ok := w.Labels[comp.AntiAffinity]
+	if !ok {
+		return fmt.Sprintf("%s has no %s label", w.Name, comp.AntiAffinity)
+	}
+	for _, other := range placed {
+		if other.Worker.Labels[comp.AntiAffinity] == value {
+			return fmt.Sprintf("%s=%s already has a replica on %s", comp.AntiAffinity, value, other.Worker.Name)
+		}
+	}
+	return ""
+}
+
+// Split the candidates into the ones that satisfy the component's constraints, and why the rest don't
+func satisfyConstraints(
+	comp database.ActiveComponent,
+	candidates []PlacementCandidate,
+	placed []PlacementCandidate) ([]PlacementCandidate, []string) {
+	allowed := make([]PlacementCandidate, 0, len(candidates))
+	rejected := make([]string, 0)
+	for _, candidate := range candidates {
+		if violation := violatesConstraints(comp, candidate.Worker, placed); violation != "" {
+			rejected = append(rejected, violation)
+		} else {
+			allowed = append(allowed, candidate)
+		}
+	}
+	return allowed, rejected
+}
+
+func explainUnplaceable(placed int, wanted int, excluded []string) string {
+	explanation := fmt.Sprintf("only %d of %d replica(s) could be placed", placed, wanted)
+	if len(excluded) == 0 {
+		return explanation + " -- there are no reachable workers left"
+	}
+	return explanation + " -- " + strings.Join(excluded, "; ")
+}
+
+func (mgr *ActionManager) unplaceableReason(compPath worker.ComponentPath) (string, bool) {
+	mgr.unplaceableMux.Lock()
+	defer mgr.unplaceableMux.Unlock()
+
+	reason, ok := mgr.unplaceableReasons[compPath]
+	if quotaReason, overQuota := mgr.overQuotaReasons[compPath]; overQuota {
+		if ok {
+			return quotaReason + "; " + reason, true
+		}
+		return quotaReason, true
+	}
+	return reason, ok
+}
+
+func (r *reconciler) setUnplaceable(compID worker.ComponentID, reason string) {
+	if r.planning {
+		r.mux.Lock()
+		r.unplaceable = append(r.unplaceable, UnplaceableComponent{ID: compID, Reason: reason})
+		r.mux.Unlock()
+		return
+	}
+
+	r.mgr.unplaceableMux.Lock()
+	defer r.mgr.unplaceableMux.Unlock()
+
+	r.mgr.unplaceableReasons[worker.ComponentPath{User: compID.User, Repo: compID.Repo}] = compID.Hash + ": " + reason
+}
+
+func (r *reconciler) clearUnplaceable(compPath worker.ComponentPath) {
+	if r.planning {
+		return
+	}
+
+	r.mgr.unplaceableMux.Lock()
+	defer r.mgr.unplaceableMux.Unlock()
+
+	delete(r.mgr.unplaceableReasons, compPath)
+}
@@ -0,0 +1,146 @@
+package deployment
+
+import (
+	"fmt"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+func (mgr *ActionManager) findWorker(name string) (*worker.V9Worker, error) {
+	w, ok := mgr.workers.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("there is no worker named %q: %w", name, database.ErrUnknownWorker)
+	}
+	return w, nil
+}
+
+// Stop placing anything new on the worker, but leave what it is running alone
+func (mgr *ActionManager) CordonWorker(name string) error {
+	w, err := mgr.findWorker(name)
+	if err != nil {
+		return err
+	}
+
+	// A worker that is draining stays that way
+	mgr.cordonMux.Lock()
+	defer mgr.cordonMux.Unlock()
+
+	err = mgr.driver.SetWorkerCordon(w.Name, database.WorkerCordon{Cordoned: true, Draining: mgr.draining[w.Name]})
+	if err != nil {
+		return err
+	}
+	mgr.cordoned[w.Name] = true
+
+	log.Info.Println("Cordoned worker", w.Name)
+	return nil
+}
+
+// Cordon the worker, and move everything it is running to other workers
+func (mgr *ActionManager) DrainWorker(name string) error {
+	w, err := mgr.findWorker(name)
+	if err != nil {
+		return err
+	}
+
+	err = mgr.driver.SetWorkerCordon(w.Name, database.WorkerCordon{Cordoned: true, Draining: true})
+	if err != nil {
+		return err
+	}
+
+	mgr.cordonMux.Lock()
+	mgr.cordoned[w.Name] = true
+	mgr.draining[w.Name] = true
+	mgr.cordonMux.Unlock()
+
+	log.Info.Println("Draining worker", w.Name)
+	mgr.NotifyComponentStateChanged()
+	return nil
+}
+
+// Put the worker back in the pool
+func (mgr *ActionManager) UncordonWorker(name string) error {
+	w, err := mgr.findWorker(name)
+	if err != nil {
+		return err
+	}
+
+	err = mgr.driver.SetWorkerCordon(w.Name, database.WorkerCordon{})
+	if err != nil {
+		return err
+	}
+
+	mgr.cordonMux.Lock()
+	delete(mgr.cordoned, w.Name)
+	delete(mgr.draining, w.Name)
+	mgr.cordonMux.Unlock()
+
+	log.Info.Println("Uncordoned worker", w.Name)
+	mgr.NotifyComponentStateChanged()
+	return nil
+}
+
+// Pick up the workers that were cordoned or draining before we (re)started
+func (mgr *ActionManager) loadCordonedWorkers() error {
+	cordons, err := mgr.driver.FindCordonedWorkers()
+	if err != nil {
+		return err
+	}
+
+	mgr.cordonMux.Lock()
+	defer mgr.cordonMux.Unlock()
+
+	mgr.cordoned = make(map[string]bool)
+	mgr.draining = make(map[string]bool)
+	for name, cordon := range cordons {
+		// Draining workers are always cordoned too
+		mgr.cordoned[name] = true
+		if cordon.Draining {
+			mgr.draining[name] = true
+		}
+	}
+	log.Info.Println("Loaded", len(cordons), "cordoned worker(s)")
+	return nil
+}
+
+func (mgr *ActionManager) isCordoned(w *worker.V9Worker) bool {
+	mgr.cordonMux.Lock()
+	defer mgr.cordonMux.Unlock()
+
+	return mgr.cordoned[w.Name]
+}
+
+func (mgr *ActionManager) isDraining(w *worker.V9Worker) bool {
+	mgr.cordonMux.Lock()
+	defer mgr.cordonMux.Unlock()
+
+	return mgr.draining[w.Name]
+}
+
+// Add the worker to the pool (or update it, if it registered before)
+func (mgr *ActionManager) RegisterWorker(name string, url string, labels map[string]string) error {
+	err := mgr.workers.Register(name, url, labels)
+	if err != nil {
+		return err
+	}
+
+	w, _ := mgr.workers.Find(name)
+	mgr.workerHealth.probeNow(w)
+	mgr.NotifyComponentStateChanged()
+	return nil
+}
+
+func (mgr *ActionManager) RecordHeartbeat(name string) error {
+	revived, err := mgr.workers.Heartbeat(name)
+	if err != nil {
+		return err
+	}
+
+	// Don't wait for the backoff to find out that the worker is back
+	if revived {
+		w, _ := mgr.workers.Find(name)
+		mgr.workerHealth.probeNow(w)
+		mgr.NotifyComponentStateChanged()
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package deployment
+
+import (
+	"time"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// Wait for updates to the component to stop for the debounce window, then deploy only the newest hash.
+// Every update that is replaced by a newer one before the window is over is a build we skipped.
+func (mgr *ActionManager) debounceHashUpdate(compPath worker.ComponentPath, hash string) {
+	if mgr.debounceWindow <= 0 {
+		mgr.applyHashUpdate(compPath, hash)
+		return
+	}
+
+	// Whatever is being built now keeps going until this hash is applied, since until then it is still the desired
+	// hash, and any pass in the meantime would only start building it again
+	mgr.debounceMux.Lock()
+	defer mgr.debounceMux.Unlock()
+
+	if previousHash, waiting := mgr.debouncedHashes[compPath]; waiting {
+		log.Info.Println("Skipping", previousHash, "of", compPath, "in favor of", hash)
+		mgr.recordSkippedBuild(compPath)
+		mgr.debounceTimers[compPath].Stop()
+	}
+
+	mgr.debouncedHashes[compPath] = hash
+	mgr.debounceTimers[compPath] = time.AfterFunc(mgr.debounceWindow, func() {
+		mgr.debounceMux.Lock()
+		newestHash, waiting := mgr.debouncedHashes[compPath]
+		// Only the newest timer gets to apply the update (an older one may fire while being stopped)
+		isNewest := waiting && newestHash == hash
+		if isNewest {
+			delete(mgr.debouncedHashes, compPath)
+			delete(mgr.debounceTimers, compPath)
+		}
+		mgr.debounceMux.Unlock()
+
+		if isNewest {
+			mgr.applyHashUpdate(compPath, newestHash)
+		}
+	})
+}
+
+func (mgr *ActionManager) applyHashUpdate(compPath worker.ComponentPath, hash string) {
+	mgr.setDesiredHash(compPath, hash)
+	mgr.NotifyComponentStateChanged()
+}
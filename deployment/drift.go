@@ -0,0 +1,145 @@
+package deployment
+
+import (
+	"fmt"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// A way the cluster differs from what it should look like
+type drift struct {
+	compPath   worker.ComponentPath
+	workerName string
+	reason     string
+}
+
+func (mgr *ActionManager) requestDriftCheck() {
+	mgr.driftMux.Lock()
+	defer mgr.driftMux.Unlock()
+
+	mgr.driftCheckPending = true
+}
+
+// Whether this pass should check for drift (only one pass does per request)
+func (mgr *ActionManager) takeDriftCheck() bool {
+	mgr.driftMux.Lock()
+	defer mgr.driftMux.Unlock()
+
+	pending := mgr.driftCheckPending
+	mgr.driftCheckPending = false
+	return pending
+}
+
+// Compare what the workers are running (and what they were running on the last pass) against what should be
+// running. Components that are being reconciled right now are expected to differ.
+func (mgr *ActionManager) detectDrift(active []database.ActiveComponent) []drift {
+	candidates := mgr.snapshot.Candidates()
+	drifts := make([]drift, 0)
+
+	activeComps := make(map[worker.ComponentPath]database.ActiveComponent, len(active))
+	activePaths := make([]worker.ComponentPath, 0, len(active))
+	for _, activeComp := range active {
+		activeComps[activeComp.Path] = activeComp
+		activePaths = append(activePaths, activeComp.Path)
+	}
+
+	// Things that disappeared from a worker without us deactivating them (a worker restart, for instance). This
+	// goes by the snapshot rather than the PollingPopulator, which lags behind what we just activated.
+	for _, gone := range mgr.snapshot.Disappeared() {
+		compPath := worker.ComponentPath{User: gone.ID.User, Repo: gone.ID.Repo}
+		if _, isActive := activeComps[compPath]; !isActive || mgr.isReconciling(compPath) {
+			continue
+		}
+		drifts = append(drifts, drift{
+			compPath:   compPath,
+			workerName: gone.WorkerName,
+			reason:     fmt.Sprintf("%s was running on %s, but is gone", gone.ID.Hash, gone.WorkerName),
+		})
+	}
+
+	// Things running that should not be
+	for _, candidate := range candidates {
+		for _, incorrectlyRunning := range candidate.Status.FindNonactive(activePaths) {
+			drifts = append(drifts, drift{
+				compPath:   worker.ComponentPath{User: incorrectlyRunning.User, Repo: incorrectlyRunning.Repo},
+				workerName: candidate.Worker.Name,
+				reason:     fmt.Sprintf("%s is running on %s, but is not active", incorrectlyRunning.Hash, candidate.Worker.Name),
+			})
+		}
+	}
+
+	// Active things that are not running the way they should be
+	for _, activeComp := range active {
+		if mgr.isReconciling(activeComp.Path) {
+			continue
+		}
+		drifts = append(drifts, mgr.detectComponentDrift(activeComp, candidates)...)
+	}
+
+	return drifts
+}
+
+func (mgr *ActionManager) detectComponentDrift(
+	activeComp database.ActiveComponent,
+	candidates []PlacementCandidate) []drift {
+	drifts := make([]drift, 0)
+	desiredHash, knowsHash := mgr.targetHash(activeComp.Path)
+	// Only replaced components are expected to run exactly one hash on exactly `Replicas` workers
+	strict := activeComp.RolloutStrategy == database.ReplaceRollout && knowsHash && desiredHash != headHashSentinel
+
+	running := 0
+	for _, candidate := range candidates {
+		for _, runningComp := range candidate.Status.ActiveComponents {
+			if runningComp.ID.User != activeComp.Path.User || runningComp.ID.Repo != activeComp.Path.Repo {
+				continue
+			}
+			if strict && runningComp.ID.Hash != desiredHash {
+				drifts = append(drifts, drift{
+					compPath:   activeComp.Path,
+					workerName: candidate.Worker.Name,
+					reason: fmt.Sprintf("%s is running %s instead of %s",
+						candidate.Worker.Name, runningComp.ID.Hash, desiredHash),
+				})
+				continue
+			}
+			running++
+		}
+	}
+
+	switch {
+	case running == 0:
+		drifts = append(drifts, drift{compPath: activeComp.Path, reason: "not running on any worker"})
+	case strict && running != activeComp.Replicas:
+		drifts = append(drifts, drift{
+			compPath: activeComp.Path,
+			reason:   fmt.Sprintf("running on %d worker(s), but %d replica(s) are wanted", running, activeComp.Replicas),
+		})
+	}
+
+	return drifts
+}
+
+// Record drift we have not seen before as an event (drift that persists is only recorded once)
+func (mgr *ActionManager) recordDrift(drifts []drift) error {
+	mgr.driftMux.Lock()
+	previouslyReported := mgr.reportedDrift
+	mgr.reportedDrift = make(map[drift]bool, len(drifts))
+	for _, d := range drifts {
+		mgr.reportedDrift[d] = true
+	}
+	mgr.driftMux.Unlock()
+
+	for _, d := range drifts {
+		if previouslyReported[d] {
+			continue
+		}
+
+		log.Warning.Println("Detected drift in", d.compPath, "--", d.reason)
+		err := mgr.driver.RecordEvent(d.compPath, d.workerName, database.DriftEvent, d.reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// A component that was running on a worker that has been unreachable for longer than the grace period
+type failover struct {
+	workerName   string
+	failingSince time.Time
+	compID       worker.ComponentID
+}
+
+// Work out what to do about the active components last seen on workers that stopped answering.
+// Within the grace period they are held, so they still count as running and nothing is moved.
+// After it, they are failed over, and the reconciler places them on healthy workers instead.
+func (mgr *ActionManager) findUnreachableComponents(
+	active []database.ActiveComponent) ([]worker.ComponentID, []failover, error) {
+	failing := mgr.workerHealth.failing()
+	if len(failing) == 0 {
+		return nil, nil, nil
+	}
+
+	lastSeen, err := mgr.driver.FindCurrentlyRunning()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	activeComps := make(map[worker.ComponentPath]bool, len(active))
+	for _, activeComp := range active {
+		activeComps[activeComp.Path] = true
+	}
+
+	now := time.Now()
+	held := make([]worker.ComponentID, 0)
+	failovers := make([]failover, 0)
+	for _, seen := range lastSeen {
+		failingSince, isFailing := failing[seen.WorkerName]
+		if !isFailing || !activeComps[worker.ComponentPath{User: seen.ID.User, Repo: seen.ID.Repo}] {
+			continue
+		}
+
+		if now.Before(failingSince.Add(mgr.failoverGracePeriod)) {
+			held = append(held, seen.ID)
+		} else {
+			failovers = append(failovers, failover{workerName: seen.WorkerName, failingSince: failingSince, compID: seen.ID})
+		}
+	}
+	return held, failovers, nil
+}
+
+// Reconcile again once the grace period of every failing worker is over
+func (mgr *ActionManager) notifyAfterGracePeriods() {
+	now := time.Now()
+	for _, failingSince := range mgr.workerHealth.failing() {
+		failoverAt := failingSince.Add(mgr.failoverGracePeriod)
+		if now.Before(failoverAt) {
+			time.AfterFunc(failoverAt.Sub(now), mgr.NotifyComponentStateChanged)
+		}
+	}
+}
+
+// Record an event for every component failed over from a worker, once per time the worker goes down
+func (mgr *ActionManager) recordFailovers(failovers []failover) error {
+	for _, f := range failovers {
+		mgr.failoverMux.Lock()
+		alreadyRecorded := mgr.failedOver[f.workerName][f.compID] == f.failingSince
+		if !alreadyRecorded {
+			if mgr.failedOver[f.workerName] == nil {
+				mgr.failedOver[f.workerName] = make(map[worker.ComponentID]time.Time)
+			}
+			mgr.failedOver[f.workerName][f.compID] = f.failingSince
+		}
+		mgr.failoverMux.Unlock()
+
+		if alreadyRecorded {
+			continue
+		}
+
+		reason := fmt.Sprintf("%s has been unreachable since %s, moving %s to a healthy worker",
+			f.workerName, f.failingSince.Format(time.RFC3339), f.compID.Hash)
+		log.Warning.Println("Failing over", f.compID, "--", reason)
+		err := mgr.driver.RecordEvent(
+			worker.ComponentPath{User: f.compID.User, Repo: f.compID.Repo}, f.workerName, database.FailoverEvent, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package deployment
+
+import (
+	"time"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// How long to wait before reconciling a failing component again, doubling with every failure up to the max
+const componentRetryBackoff = 10 * time.Second
+const componentMaxRetryBackoff = 10 * time.Minute
+
+type componentFailure struct {
+	consecutiveFailures int
+	lastErr             error
+	lastFailure         time.Time
+	nextRetry           time.Time
+	// The hash the component was failing to get to (a new one is worth trying right away)
+	hash string
+}
+
+func (mgr *ActionManager) componentFailure(compPath worker.ComponentPath) (componentFailure, bool) {
+	mgr.failureMux.Lock()
+	defer mgr.failureMux.Unlock()
+
+	failure, failing := mgr.componentFailures[compPath]
+	return failure, failing
+}
+
+// Whether the component should be reconciled now, or left alone until its backoff is over
+func (mgr *ActionManager) shouldRetry(compPath worker.ComponentPath, now time.Time) bool {
+	failure, failing := mgr.componentFailure(compPath)
+	if !failing || !now.Before(failure.nextRetry) {
+		return true
+	}
+	hash, _ := mgr.targetHash(compPath)
+	return hash != failure.hash
+}
+
+func (mgr *ActionManager) recordComponentSuccess(compPath worker.ComponentPath) {
+	mgr.failureMux.Lock()
+	defer mgr.failureMux.Unlock()
+
+	if previous, failing := mgr.componentFailures[compPath]; failing {
+		log.Info.Println("Reconciled", compPath, "again after", previous.consecutiveFailures, "failure(s)")
+		delete(mgr.componentFailures, compPath)
+	}
+}
+
+func (mgr *ActionManager) recordComponentFailure(compPath worker.ComponentPath, err error, now time.Time) {
+	hash, _ := mgr.targetHash(compPath)
+
+	mgr.failureMux.Lock()
+	defer mgr.failureMux.Unlock()
+
+	failure := mgr.componentFailures[compPath]
+	if failure.hash != hash {
+		// Failing to get to some other hash says nothing about this one
+		failure = componentFailure{hash: hash}
+	}
+	failure.consecutiveFailures++
+	failure.lastErr = err
+	failure.lastFailure = now
+
+	backoff := componentRetryBackoff
+	for i := 1; i < failure.consecutiveFailures && backoff < componentMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > componentMaxRetryBackoff {
+		backoff = componentMaxRetryBackoff
+	}
+	failure.nextRetry = now.Add(backoff)
+	mgr.componentFailures[compPath] = failure
+
+	log.Error.Println("Could not reconcile component", compPath, ":", err, "-- retrying in", backoff)
+	time.AfterFunc(backoff, mgr.NotifyComponentStateChanged)
+}
@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"v9_deployment_manager/worker"
+)
+
+// Counters about what the ActionManager did (or didn't do), since it started
+type Metrics struct {
+	// Updates that were replaced by a newer one before they were deployed
+	SkippedBuilds            int            `json:"skipped_builds"`
+	SkippedBuildsByComponent map[string]int `json:"skipped_builds_by_component"`
+
+	// Builds that were stopped partway through because a newer hash came along
+	CancelledBuilds            int            `json:"cancelled_builds"`
+	CancelledBuildsByComponent map[string]int `json:"cancelled_builds_by_component"`
+}
+
+func newMetrics() Metrics {
+	return Metrics{
+		SkippedBuildsByComponent:   make(map[string]int),
+		CancelledBuildsByComponent: make(map[string]int),
+	}
+}
+
+func (mgr *ActionManager) recordSkippedBuild(compPath worker.ComponentPath) {
+	mgr.metricsMux.Lock()
+	defer mgr.metricsMux.Unlock()
+
+	mgr.metrics.SkippedBuilds++
+	mgr.metrics.SkippedBuildsByComponent[compPath.User+"/"+compPath.Repo]++
+}
+
+func (mgr *ActionManager) recordCancelledBuild(compPath worker.ComponentPath) {
+	mgr.metricsMux.Lock()
+	defer mgr.metricsMux.Unlock()
+
+	mgr.metrics.CancelledBuilds++
+	mgr.metrics.CancelledBuildsByComponent[compPath.User+"/"+compPath.Repo]++
+}
+
+func (mgr *ActionManager) Metrics() Metrics {
+	mgr.metricsMux.Lock()
+	defer mgr.metricsMux.Unlock()
+
+	metrics := mgr.metrics
+	metrics.SkippedBuildsByComponent = make(map[string]int, len(mgr.metrics.SkippedBuildsByComponent))
+	for comp, skipped := range mgr.metrics.SkippedBuildsByComponent {
+		metrics.SkippedBuildsByComponent[comp] = skipped
+	}
+	metrics.CancelledBuildsByComponent = make(map[string]int, len(mgr.metrics.CancelledBuildsByComponent))
+	for comp, cancelled := range mgr.metrics.CancelledBuildsByComponent {
+		metrics.CancelledBuildsByComponent[comp] = cancelled
+	}
+	return metrics
+}
@@ -0,0 +1,91 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"v9_deployment_manager/activator"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+var ErrRolledBackHash = errors.New("hash was rolled back before")
+var ErrUnknownHash = errors.New("hash is not in the repo")
+
+func (mgr *ActionManager) pinnedHash(compPath worker.ComponentPath) (string, bool) {
+	mgr.pinMux.Lock()
+	defer mgr.pinMux.Unlock()
+
+	hash, ok := mgr.pinnedHashes[compPath]
+	return hash, ok
+}
+
+// The hash the component should be running -- its pin if it has one, and otherwise whatever was pushed last
+func (mgr *ActionManager) targetHash(compPath worker.ComponentPath) (string, bool) {
+	if hash, pinned := mgr.pinnedHash(compPath); pinned {
+		return hash, true
+	}
+	return mgr.desiredHash(compPath)
+}
+
+// Hold the component on the hash until it is unpinned. Pushes are still tracked in the meantime.
+func (mgr *ActionManager) PinComponent(compPath worker.ComponentPath, hash string) error {
+	isBad, err := mgr.driver.IsBadHash(worker.ComponentID{User: compPath.User, Repo: compPath.Repo, Hash: hash})
+	if err != nil {
+		return err
+	}
+	if isBad {
+		return fmt.Errorf("cannot pin %v to %s: %w", compPath, hash, ErrRolledBackHash)
+	}
+	// A pin that can't be checked out would only fail every build until it is unpinned
+	err = mgr.activator.VerifyHash(worker.ComponentID{User: compPath.User, Repo: compPath.Repo, Hash: hash})
+	if errors.Is(err, activator.ErrUnknownRevision) {
+		return fmt.Errorf("cannot pin %v to %s: %w", compPath, hash, ErrUnknownHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	mgr.pinMux.Lock()
+	defer mgr.pinMux.Unlock()
+
+	err = mgr.driver.SetPinnedHash(compPath, hash)
+	if err != nil {
+		return err
+	}
+	mgr.pinnedHashes[compPath] = hash
+	mgr.cancelBuildsOtherThan(compPath, hash)
+
+	log.Info.Println("Pinned", compPath, "to", hash)
+	mgr.NotifyComponentStateChanged()
+	return nil
+}
+
+// Go back to deploying whatever was pushed last
+func (mgr *ActionManager) UnpinComponent(compPath worker.ComponentPath) error {
+	mgr.pinMux.Lock()
+	defer mgr.pinMux.Unlock()
+
+	err := mgr.driver.SetPinnedHash(compPath, "")
+	if err != nil {
+		return err
+	}
+	delete(mgr.pinnedHashes, compPath)
+
+	log.Info.Println("Unpinned", compPath)
+	mgr.NotifyComponentStateChanged()
+	return nil
+}
+
+func (mgr *ActionManager) loadPinnedHashes() error {
+	hashes, err := mgr.driver.FindPinnedHashes()
+	if err != nil {
+		return err
+	}
+
+	mgr.pinMux.Lock()
+	defer mgr.pinMux.Unlock()
+
+	mgr.pinnedHashes = hashes
+	log.Info.Println("Loaded", len(hashes), "pinned hash(es)")
+	return nil
+}
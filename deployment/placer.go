@@ -0,0 +1,157 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"v9_deployment_manager/worker"
+)
+
+const (
+	RandomPlacement           = "random"
+	LeastLoadedPlacement      = "least-loaded"
+	FewestComponentsPlacement = "fewest-components"
+	RoundRobinPlacement       = "round-robin"
+)
+
+var errNoPlacementCandidates = errors.New("no workers available to place component on")
+
+// A worker that a component could be placed on, along with its most recent status
+type PlacementCandidate struct {
+	Worker *worker.V9Worker
+	Status worker.StatusResponse
+}
+
+type PlacementDecision struct {
+	Worker *worker.V9Worker
+	Reason string
+}
+
+// A Placer decides which of the candidate workers a component should be activated on
+type Placer interface {
+	Place(compID worker.ComponentID, candidates []PlacementCandidate) (PlacementDecision, error)
+}
+
+// A Placer that keeps state between placements, like whose turn it is, and can hand out a copy of it for planning
+// so that a plan doesn't change what the next real placement will be
+type statefulPlacer interface {
+	Placer
+	copyForPlanning() Placer
+}
+
+// The placer to plan with. Stateless placers can be shared as they are.
+func planningPlacer(placer Placer) Placer {
+	if stateful, ok := placer.(statefulPlacer); ok {
+		return stateful.copyForPlanning()
+	}
+	return placer
+}
+
+func NewPlacer(strategy string) (Placer, error) {
+	switch strategy {
+	case RandomPlacement:
+		return &randomPlacer{}, nil
+	case LeastLoadedPlacement:
+		return &leastLoadedPlacer{}, nil
+	case FewestComponentsPlacement:
+		return &fewestComponentsPlacer{}, nil
+	case RoundRobinPlacement:
+		return &roundRobinPlacer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement strategy %q", strategy)
+	}
+}
+
+type randomPlacer struct{}
+
+func (p *randomPlacer) Place(_ worker.ComponentID, candidates []PlacementCandidate) (PlacementDecision, error) {
+	if len(candidates) == 0 {
+		return PlacementDecision{}, errNoPlacementCandidates
+	}
+
+	chosen := candidates[rand.Intn(len(candidates))]
+	return PlacementDecision{
+		Worker: chosen.Worker,
+		Reason: fmt.Sprintf("picked randomly from %d candidate(s)", len(candidates)),
+	}, nil
+}
+
+// Picks the worker with the lowest combined CPU and memory usage
+type leastLoadedPlacer struct{}
+
+func load(status worker.StatusResponse) float64 {
+	return status.CPUUsage + status.MemoryUsage
+}
+
+func (p *leastLoadedPlacer) Place(_ worker.ComponentID, candidates []PlacementCandidate) (PlacementDecision, error) {
+	if len(candidates) == 0 {
+		return PlacementDecision{}, errNoPlacementCandidates
+	}
+
+	chosen := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if load(candidate.Status) < load(chosen.Status) {
+			chosen = candidate
+		}
+	}
+
+	return PlacementDecision{
+		Worker: chosen.Worker,
+		Reason: fmt.Sprintf("least loaded of %d candidate(s) (cpu %.2f, memory %.2f)",
+			len(candidates), chosen.Status.CPUUsage, chosen.Status.MemoryUsage),
+	}, nil
+}
+
+// Picks the worker running the fewest components
+type fewestComponentsPlacer struct{}
+
+func (p *fewestComponentsPlacer) Place(
+	_ worker.ComponentID,
+	candidates []PlacementCandidate) (PlacementDecision, error) {
+	if len(candidates) == 0 {
+		return PlacementDecision{}, errNoPlacementCandidates
+	}
+
+	chosen := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if len(candidate.Status.ActiveComponents) < len(chosen.Status.ActiveComponents) {
+			chosen = candidate
+		}
+	}
+
+	return PlacementDecision{
+		Worker: chosen.Worker,
+		Reason: fmt.Sprintf("fewest components of %d candidate(s) (%d running)",
+			len(candidates), len(chosen.Status.ActiveComponents)),
+	}, nil
+}
+
+// Cycles through the candidates, one placement at a time
+type roundRobinPlacer struct {
+	mux  sync.Mutex
+	next int
+}
+
+func (p *roundRobinPlacer) Place(_ worker.ComponentID, candidates []PlacementCandidate) (PlacementDecision, error) {
+	if len(candidates) == 0 {
+		return PlacementDecision{}, errNoPlacementCandidates
+	}
+
+	p.mux.Lock()
+	index := p.next % len(candidates)
+	p.next++
+	p.mux.Unlock()
+
+	return PlacementDecision{
+		Worker: candidates[index].Worker,
+		Reason: fmt.Sprintf("round robin turn %d of %d candidate(s)", index+1, len(candidates)),
+	}, nil
+}
+
+func (p *roundRobinPlacer) copyForPlanning() Placer {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return &roundRobinPlacer{next: p.next}
+}
@@ -0,0 +1,113 @@
+package deployment
+
+import (
+	"fmt"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+const (
+	ActivateAction   = "activate"
+	DeactivateAction = "deactivate"
+)
+
+// Something the reconciler would do to a worker
+type Action struct {
+	Type      string             `json:"type"`
+	ID        worker.ComponentID `json:"id"`
+	WorkerURL string             `json:"worker_url"`
+	Color     string             `json:"color,omitempty"`
+	Reason    string             `json:"reason"`
+}
+
+func (action Action) String() string {
+	return fmt.Sprintf("%s %s/%s@%s on worker %s -- %s",
+		action.Type, action.ID.User, action.ID.Repo, action.ID.Hash, action.WorkerURL, action.Reason)
+}
+
+// What the next reconciliation pass would do
+type Plan struct {
+	Actions     []Action               `json:"actions"`
+	Unplaceable []UnplaceableComponent `json:"unplaceable"`
+}
+
+// Work out, in order, what the next reconciliation pass would do -- without doing any of it
+func (mgr *ActionManager) Plan() (Plan, error) {
+	active, unreadable, err := mgr.driver.FindActiveComponents()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	// Ask every worker, without touching what the manager knows about their health
+	snapshot := NewClusterSnapshot()
+	snapshot.Refresh(mgr.heartbeatingWorkers(false), nil)
+	held, _, err := mgr.findUnreachableComponents(active)
+	if err != nil {
+		return Plan{}, err
+	}
+	snapshot.SetHeld(held)
+
+	active, unplaceable, unchecked := mgr.enforceQuotas(active, snapshot)
+
+	// components we can't make sense of are left as they are
+	skipped := make([]worker.ComponentPath, 0)
+	for _, comp := range append(unreadable, unchecked...) {
+		skipped = append(skipped, comp.Path)
+		unplaceable = append(unplaceable, UnplaceableComponent{
+			ID:     worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo},
+			Reason: comp.Err.Error(),
+		})
+	}
+
+	planner := mgr.newPlanner(snapshot)
+	err = planner.reconcileAll(active, skipped)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{
+		Actions:     planner.actions,
+		Unplaceable: append(unplaceable, planner.unplaceable...),
+	}
+	if plan.Actions == nil {
+		plan.Actions = make([]Action, 0)
+	}
+	if plan.Unplaceable == nil {
+		plan.Unplaceable = make([]UnplaceableComponent, 0)
+	}
+	return plan, nil
+}
+
+func (mgr *ActionManager) logPlan() error {
+	plan, err := mgr.Plan()
+	if err != nil {
+		return err
+	}
+
+	log.Info.Println("Dry run: the plan has", len(plan.Actions), "action(s)")
+	for i, action := range plan.Actions {
+		log.Info.Println("Dry run:", i+1, action)
+	}
+	for _, unplaceable := range plan.Unplaceable {
+		log.Info.Println("Dry run: cannot place", unplaceable.ID, "--", unplaceable.Reason)
+	}
+	return nil
+}
+
+func (r *reconciler) setBlueGreenState(compPath worker.ComponentPath, state blueGreenState) {
+	if !r.planning {
+		r.mgr.setBlueGreenState(compPath, state)
+	}
+}
+
+func (r *reconciler) setCanaryState(compPath worker.ComponentPath, state canaryState) {
+	if !r.planning {
+		r.mgr.setCanaryState(compPath, state)
+	}
+}
+
+func (r *reconciler) clearCanaryState(compPath worker.ComponentPath) {
+	if !r.planning {
+		r.mgr.clearCanaryState(compPath)
+	}
+}
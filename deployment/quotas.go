@@ -0,0 +1,112 @@
+package deployment
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// Leave out active components (and replicas) that go over their user's quota, in case the quota was lowered or the
+// database was changed behind the API's back. Components that are already running are kept before new ones.
+// Components of users whose quota can't be found are left out as unreadable, without holding up everyone else.
+func (mgr *ActionManager) enforceQuotas(
+	active []database.ActiveComponent,
+	snapshot *ClusterSnapshot) ([]database.ActiveComponent, []UnplaceableComponent, []database.UnreadableComponent) {
+	candidates := snapshot.Candidates()
+	isRunning := func(comp database.ActiveComponent) bool {
+		_, running := findRunningHash(candidates, comp.Path)
+		return running
+	}
+
+	byUser := make(map[string][]database.ActiveComponent)
+	users := make([]string, 0)
+	for _, activeComp := range active {
+		if _, seen := byUser[activeComp.Path.User]; !seen {
+			users = append(users, activeComp.Path.User)
+		}
+		byUser[activeComp.Path.User] = append(byUser[activeComp.Path.User], activeComp)
+	}
+
+	allowed := make([]database.ActiveComponent, 0, len(active))
+	rejected := make([]UnplaceableComponent, 0)
+	unreadable := make([]database.UnreadableComponent, 0)
+	for _, user := range users {
+		quota, err := mgr.driver.FindQuota(user)
+		if err != nil {
+			for _, comp := range byUser[user] {
+				unreadable = append(unreadable, database.UnreadableComponent{Path: comp.Path, Err: err})
+			}
+			continue
+		}
+
+		comps := byUser[user]
+		sort.SliceStable(comps, func(i, j int) bool {
+			if isRunning(comps[i]) != isRunning(comps[j]) {
+				return isRunning(comps[i])
+			}
+			return comps[i].Path.Repo < comps[j].Path.Repo
+		})
+
+		replicas := 0
+		for i, comp := range comps {
+			compID := worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo}
+			if err = quota.CheckActiveComponents(user, i+1); err != nil {
+				rejected = append(rejected, UnplaceableComponent{ID: compID, Reason: err.Error()})
+				continue
+			}
+
+			if err = quota.CheckReplicas(user, replicas+comp.Replicas); err != nil {
+				// Run as many replicas as the quota still allows
+				remaining := *quota.MaxReplicas - replicas
+				if remaining < 1 {
+					rejected = append(rejected, UnplaceableComponent{ID: compID, Reason: err.Error()})
+					continue
+				}
+				rejected = append(rejected, UnplaceableComponent{
+					ID:     compID,
+					Reason: fmt.Sprintf("only running %d replica(s) -- %s", remaining, err),
+				})
+				comp.Replicas = remaining
+			}
+
+			replicas += comp.Replicas
+			allowed = append(allowed, comp)
+		}
+	}
+
+	return allowed, rejected, unreadable
+}
+
+// Remember why components were limited on this pass (replacing what we remembered from the last one)
+func (mgr *ActionManager) recordOverQuota(overQuota []UnplaceableComponent) {
+	reasons := make(map[worker.ComponentPath]string, len(overQuota))
+	for _, comp := range overQuota {
+		log.Warning.Println("Limiting", comp.ID.User+"/"+comp.ID.Repo, "--", comp.Reason)
+		reasons[worker.ComponentPath{User: comp.ID.User, Repo: comp.ID.Repo}] = comp.Reason
+	}
+
+	mgr.unplaceableMux.Lock()
+	defer mgr.unplaceableMux.Unlock()
+
+	mgr.overQuotaReasons = reasons
+}
+
+// Check that building compID would not go over its user's builds per hour
+func (mgr *ActionManager) checkBuildQuota(compID worker.ComponentID) error {
+	quota, err := mgr.driver.FindQuota(compID.User)
+	if err != nil {
+		return err
+	}
+	if quota.MaxBuildsPerHour == nil {
+		return nil
+	}
+
+	builds, err := mgr.driver.CountBuildsSince(compID.User, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	return quota.CheckBuildsPerHour(compID.User, builds+1)
+}
@@ -0,0 +1,494 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"v9_deployment_manager/activator"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// Works out what it takes to bring components to their desired state, and does it.
+// When planning, nothing is done to the workers or to the manager's state -- the actions are only recorded.
+type reconciler struct {
+	mgr      *ActionManager
+	snapshot *ClusterSnapshot
+	placer   Placer
+	planning bool
+
+	mux         sync.Mutex
+	actions     []Action
+	unplaceable []UnplaceableComponent
+	// Desired hashes the plan changed, without changing them on the manager
+	plannedHashes map[worker.ComponentPath]string
+	// The build sweep of each component from before we read the hash it wants
+	buildSweeps map[worker.ComponentPath]uint64
+}
+
+func (mgr *ActionManager) newReconciler() *reconciler {
+	return &reconciler{
+		mgr:         mgr,
+		snapshot:    mgr.snapshot,
+		placer:      mgr.placer,
+		buildSweeps: make(map[worker.ComponentPath]uint64),
+	}
+}
+
+func (mgr *ActionManager) newPlanner(snapshot *ClusterSnapshot) *reconciler {
+	return &reconciler{
+		mgr:           mgr,
+		snapshot:      snapshot,
+		placer:        planningPlacer(mgr.placer),
+		planning:      true,
+		plannedHashes: make(map[worker.ComponentPath]string),
+	}
+}
+
+func (r *reconciler) record(action Action) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.actions = append(r.actions, action)
+}
+
+func (r *reconciler) desiredHash(compPath worker.ComponentPath) (string, bool) {
+	// Pins win over everything else
+	if hash, pinned := r.mgr.pinnedHash(compPath); pinned {
+		return hash, true
+	}
+	if r.planning {
+		r.mux.Lock()
+		hash, ok := r.plannedHashes[compPath]
+		r.mux.Unlock()
+		if ok {
+			return hash, ok
+		}
+	}
+	return r.mgr.desiredHash(compPath)
+}
+
+func (r *reconciler) setDesiredHash(compPath worker.ComponentPath, hash string) {
+	if r.planning {
+		r.mux.Lock()
+		r.plannedHashes[compPath] = hash
+		r.mux.Unlock()
+		return
+	}
+	r.mgr.setDesiredHash(compPath, hash)
+}
+
+func (r *reconciler) resolveDesiredHash(compPath worker.ComponentPath, hash string) {
+	// A plan never resolves HEAD, and whatever is running is already what it falls back to
+	if r.planning {
+		return
+	}
+	r.mgr.resolveDesiredHash(compPath, hash)
+}
+
+// Reconcile again after a while (never when planning)
+func (r *reconciler) notifyAfter(d time.Duration) {
+	if r.planning {
+		return
+	}
+	time.AfterFunc(d, r.mgr.NotifyComponentStateChanged)
+}
+
+// Deactivate components that should not be running anywhere, then reconcile each active component in turn
+func (r *reconciler) reconcileAll(active []database.ActiveComponent, skipped []worker.ComponentPath) error {
+	activePaths := make([]worker.ComponentPath, len(active))
+	for i, activeComp := range active {
+		activePaths[i] = activeComp.Path
+	}
+	// Skipped components are still active, so whatever they run stays where it is
+	activePaths = append(activePaths, skipped...)
+
+	err := r.deactivateAllNonactive(activePaths)
+	if err != nil {
+		return err
+	}
+
+	for _, activeComp := range active {
+		err = r.reconcileComponent(activeComp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keeps going after an error, so one broken worker doesn't keep the others running what they shouldn't
+func (r *reconciler) deactivateAllNonactive(activePaths []worker.ComponentPath) error {
+	var firstErr error
+	failed := 0
+	for _, candidate := range r.snapshot.Candidates() {
+		err := r.deactivateNonactive(candidate, activePaths)
+		if err != nil {
+			log.Error.Println("Could not deactivate non-active components on", candidate.Worker.Name, ":", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("could not deactivate non-active components on %d worker(s): %w", failed, firstErr)
+	}
+	return nil
+}
+
+func (r *reconciler) reconcileComponent(comp database.ActiveComponent) error {
+	if !r.planning {
+		log.Info.Println("Reconciling", comp.Path)
+		r.mux.Lock()
+		r.buildSweeps[comp.Path] = r.mgr.buildSweep(comp.Path)
+		r.mux.Unlock()
+	}
+	r.clearUnplaceable(comp.Path)
+
+	var err error
+	switch comp.RolloutStrategy {
+	case database.BlueGreenRollout:
+		err = r.reconcileBlueGreen(comp)
+	case database.CanaryRollout:
+		// A pin is a deliberate choice, so there is nothing to try out first
+		if _, pinned := r.mgr.pinnedHash(comp.Path); pinned {
+			r.clearCanaryState(comp.Path)
+			err = r.reconcileReplace(comp)
+		} else {
+			err = r.reconcileCanary(comp)
+		}
+	default:
+		err = r.reconcileReplace(comp)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.watchForRegression(comp)
+	if err != nil {
+		return err
+	}
+
+	if !r.planning {
+		log.Info.Println("Finished reconciling", comp.Path)
+	}
+	return nil
+}
+
+// The hash the component should be running. Falls back to whatever is already running, and then to HEAD.
+func (r *reconciler) findCorrectCompID(compPath worker.ComponentPath) worker.ComponentID {
+	compID := worker.ComponentID{
+		User: compPath.User,
+		Repo: compPath.Repo,
+		Hash: headHashSentinel,
+	}
+	if mapHash, ok := r.desiredHash(compPath); ok {
+		compID.Hash = mapHash
+	} else if runningHash, ok := findRunningHash(r.snapshot.Candidates(), compPath); ok {
+		// If we don't know what is supposed to be running, whatever is already running is fine
+		compID.Hash = runningHash
+		r.resolveDesiredHash(compPath, runningHash)
+	}
+	return compID
+}
+
+// Replace old hashes with the new one, worker by worker
+func (r *reconciler) reconcileReplace(comp database.ActiveComponent) error {
+	// make sure the component is running on exactly as many workers as it wants replicas
+	correctCompID, err := r.convergeReplicas(comp, r.findCorrectCompID(comp.Path), "", nil)
+	if err != nil {
+		return err
+	}
+
+	// deactivate workers running old hashes of the component
+	return r.deactivateOtherHashes(correctCompID, nil)
+}
+
+// Activate the component on the worker, and keep the snapshot in sync
+func (r *reconciler) activate(
+	compID worker.ComponentID,
+	w *worker.V9Worker,
+	color string,
+	reason string) (string, error) {
+	action := Action{Type: ActivateAction, ID: compID, WorkerURL: w.URL, Color: color, Reason: reason}
+	if r.planning {
+		r.record(action)
+		r.snapshot.RecordActivation(w, compID)
+		return compID.Hash, nil
+	}
+
+	// Building counts against the user's builds per hour, copying a bundle another replica built doesn't
+	if !r.mgr.activator.IsBuilt(compID) {
+		err := r.mgr.checkBuildQuota(compID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Info.Println("Doing", action)
+	ctx, finishBuild := r.mgr.startBuild(compID)
+	if r.wantsOtherHash(compID) {
+		finishBuild()
+		compPath := worker.ComponentPath{User: compID.User, Repo: compID.Repo}
+		log.Info.Println("Not activating", compID, "since", compPath, "wants a different hash now")
+		r.mgr.recordCancelledBuild(compPath)
+		return "", context.Canceled
+	}
+	activatedHash, err := r.mgr.activator.Activate(ctx, compID, w, color, reason)
+	finishBuild()
+	var badHashErr *activator.BadHashError
+	if errors.As(err, &badHashErr) && compID.Hash == headHashSentinel {
+		// HEAD is something we rolled back, so stay on the last known-good hash until something new is pushed
+		compPath := worker.ComponentPath{User: compID.User, Repo: compID.Repo}
+		if goodHash, ok := r.mgr.knownGoodHash(compPath); ok {
+			log.Warning.Println("HEAD of", compPath, "was rolled back before, staying on", goodHash)
+			r.resolveDesiredHash(compPath, goodHash)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	compID.Hash = activatedHash
+	r.snapshot.RecordActivation(w, compID)
+	return activatedHash, nil
+}
+
+// Whether the component's hash changed after we read it (and before its build was registered, or it would have
+// been cancelled). Once the build is registered, any later change cancels it like any other build.
+func (r *reconciler) wantsOtherHash(compID worker.ComponentID) bool {
+	compPath := worker.ComponentPath{User: compID.User, Repo: compID.Repo}
+
+	r.mux.Lock()
+	sweep, ok := r.buildSweeps[compPath]
+	r.mux.Unlock()
+	return ok && r.mgr.buildSweep(compPath) != sweep
+}
+
+// Deactivate the component on the worker, and keep the snapshot in sync
+func (r *reconciler) deactivate(compID worker.ComponentID, w *worker.V9Worker, reason string) error {
+	action := Action{Type: DeactivateAction, ID: compID, WorkerURL: w.URL, Reason: reason}
+	if r.planning {
+		r.record(action)
+		r.snapshot.RecordDeactivation(w, compID)
+		return nil
+	}
+
+	log.Info.Println("Doing", action)
+	err := r.mgr.activator.Deactivate(compID, w, reason)
+	if err != nil {
+		return err
+	}
+
+	r.snapshot.RecordDeactivation(w, compID)
+	return nil
+}
+
+func (r *reconciler) deactivateNonactive(candidate PlacementCandidate, active []worker.ComponentPath) error {
+	nonActive := candidate.Status.FindNonactive(active)
+	for _, incorrectlyRunning := range nonActive {
+		err := r.deactivate(incorrectlyRunning, candidate.Worker, "component is not active")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findRunningHash(statuses []PlacementCandidate, compPath worker.ComponentPath) (string, bool) {
+	for _, candidate := range statuses {
+		for _, runningComp := range candidate.Status.ActiveComponents {
+			if runningComp.ID.User == compPath.User && runningComp.ID.Repo == compPath.Repo {
+				return runningComp.ID.Hash, true
+			}
+		}
+	}
+	return "", false
+}
+
+func findCandidate(candidates []PlacementCandidate, w *worker.V9Worker) PlacementCandidate {
+	for _, candidate := range candidates {
+		if candidate.Worker == w {
+			return candidate
+		}
+	}
+	return PlacementCandidate{Worker: w}
+}
+
+func removeCandidate(candidates []PlacementCandidate, w *worker.V9Worker) []PlacementCandidate {
+	remaining := make([]PlacementCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.Worker != w {
+			remaining = append(remaining, candidate)
+		}
+	}
+	return remaining
+}
+
+func containsHash(hashes []string, hash string) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the worker is running a version of the component that is neither compID nor one of the kept hashes
+func runsOtherHash(status worker.StatusResponse, compID worker.ComponentID, keep []string) bool {
+	for _, runningComp := range status.ActiveComponents {
+		if runningComp.ID.User == compID.User && runningComp.ID.Repo == compID.Repo &&
+			runningComp.ID.Hash != compID.Hash && !containsHash(keep, runningComp.ID.Hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Make sure exactly `comp.Replicas` workers are running compID (activating it with the given color).
+// Other versions of the component are deactivated to make room, unless they are one of the kept hashes.
+// Returns compID with HEAD resolved to a real hash, if it had to be activated.
+func (r *reconciler) convergeReplicas(
+	comp database.ActiveComponent,
+	compID worker.ComponentID,
+	color string,
+	keep []string) (worker.ComponentID, error) {
+	// Sort the workers by what they are doing with this component
+	running := make([]PlacementCandidate, 0)
+	draining := make([]PlacementCandidate, 0)
+	nothingToReplace := make([]PlacementCandidate, 0)
+	runningOtherVersion := make([]PlacementCandidate, 0)
+	// Why workers can't take a replica, in case we end up with nowhere to put one
+	excluded := make([]string, 0)
+	for _, candidate := range r.snapshot.Candidates() {
+		switch {
+		case r.mgr.isDraining(candidate.Worker):
+			// Replicas on draining workers don't count, so replacements get activated before they are removed
+			if candidate.Status.ContainsExactly(compID) {
+				draining = append(draining, candidate)
+			}
+			excluded = append(excluded, candidate.Worker.Name+" is draining")
+		case candidate.Status.ContainsExactly(compID):
+			running = append(running, candidate)
+			excluded = append(excluded, candidate.Worker.Name+" already runs it")
+		case r.mgr.isCordoned(candidate.Worker):
+			// Nothing new goes on cordoned workers
+			excluded = append(excluded, candidate.Worker.Name+" is cordoned")
+		case runsOtherHash(candidate.Status, compID, keep):
+			runningOtherVersion = append(runningOtherVersion, candidate)
+		default:
+			nothingToReplace = append(nothingToReplace, candidate)
+		}
+	}
+
+	// Replicas on workers that only just stopped answering still count, until they are failed over
+	held := r.snapshot.HeldReplicas(compID)
+
+	// Scale up, preferring workers where we don't have to replace another version of this component
+	for len(running)+held < comp.Replicas {
+		candidates, rejected := satisfyConstraints(comp, nothingToReplace, running)
+		if len(candidates) == 0 {
+			var rejectedOther []string
+			candidates, rejectedOther = satisfyConstraints(comp, runningOtherVersion, running)
+			rejected = append(rejected, rejectedOther...)
+		}
+		if len(candidates) == 0 {
+			reason := explainUnplaceable(len(running)+held, comp.Replicas, append(excluded, rejected...))
+			log.Warning.Println("Cannot place", compID, "--", reason)
+			r.setUnplaceable(compID, reason)
+			break
+		}
+
+		decision, err := r.placer.Place(compID, candidates)
+		if err != nil {
+			return compID, err
+		}
+		chosen := findCandidate(candidates, decision.Worker)
+		nothingToReplace = removeCandidate(nothingToReplace, decision.Worker)
+		runningOtherVersion = removeCandidate(runningOtherVersion, decision.Worker)
+
+		// Make room on the worker if it is running some other version
+		err = r.deactivateIfHashDiffers(chosen, compID, keep)
+		if err != nil {
+			return compID, err
+		}
+
+		reason := fmt.Sprintf("replica %d of %d -- %s", len(running)+held+1, comp.Replicas, decision.Reason)
+		activatedHash, err := r.activate(compID, decision.Worker, color, reason)
+		if err != nil {
+			return compID, err
+		}
+
+		// Update the relevant hash (if we're using HEAD) so the map will match in the update step
+		if compID.Hash == headHashSentinel {
+			compID.Hash = activatedHash
+			r.resolveDesiredHash(comp.Path, activatedHash)
+		}
+		running = append(running, PlacementCandidate{Worker: decision.Worker})
+	}
+
+	// Now that the replacements are up, take the component off draining workers
+	for _, drained := range draining {
+		if len(running)+held < comp.Replicas {
+			log.Warning.Println("Leaving", compID, "on draining worker", drained.Worker.Name,
+				"since there is nowhere to move it")
+			continue
+		}
+
+		err := r.deactivate(compID, drained.Worker, fmt.Sprintf("worker %s is being drained", drained.Worker.Name))
+		if err != nil {
+			return compID, err
+		}
+	}
+
+	// Scale down, if we have too many replicas (held ones may never come back, so they don't count here)
+	for len(running) > comp.Replicas {
+		extra := running[len(running)-1]
+		running = running[:len(running)-1]
+
+		err := r.deactivate(compID, extra.Worker, fmt.Sprintf("only %d replica(s) wanted", comp.Replicas))
+		if err != nil {
+			return compID, err
+		}
+	}
+
+	return compID, nil
+}
+
+// Deactivate every version of the component other than compID and the kept hashes, wherever it is running
+func (r *reconciler) deactivateOtherHashes(compID worker.ComponentID, keep []string) error {
+	// If we still don't know what is supposed to be running, whatever -- assume we're chugging along fine
+	if compID.Hash == headHashSentinel {
+		return nil
+	}
+
+	for _, candidate := range r.snapshot.Candidates() {
+		err := r.deactivateIfHashDiffers(candidate, compID, keep)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *reconciler) deactivateIfHashDiffers(
+	candidate PlacementCandidate,
+	compID worker.ComponentID,
+	keep []string) error {
+	for _, runningComp := range candidate.Status.ActiveComponents {
+		if runningComp.ID.User == compID.User && runningComp.ID.Repo == compID.Repo &&
+			runningComp.ID.Hash != compID.Hash && !containsHash(keep, runningComp.ID.Hash) {
+			err := r.deactivate(runningComp.ID, candidate.Worker, "replaced by "+compID.Hash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
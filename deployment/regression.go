@@ -0,0 +1,38 @@
+package deployment
+
+import (
+	"fmt"
+	"v9_deployment_manager/database"
+)
+
+// How much worse (relative to the baseline) latency can get before we call it a regression
+const latencyRegressionTolerance = 0.25
+
+// How much higher (in absolute terms) the error rate can get before we call it a regression
+const errorRateRegressionTolerance = 0.05
+
+// Compare how a candidate hash is doing against a baseline hash.
+// Returns a description of the regression, if the candidate is clearly worse.
+func findRegression(baseline database.HashHealth, candidate database.HashHealth) (string, bool) {
+	if candidate.ErrorRate() > baseline.ErrorRate()+errorRateRegressionTolerance {
+		return fmt.Sprintf("error rate went from %.1f%% to %.1f%%",
+			baseline.ErrorRate()*100, candidate.ErrorRate()*100), true
+	}
+
+	// Without traffic on both sides there is nothing to compare the latency with
+	if baseline.Hits == 0 || candidate.Hits == 0 {
+		return "", false
+	}
+
+	if candidate.AvgMsLatency > baseline.AvgMsLatency*(1+latencyRegressionTolerance) {
+		return fmt.Sprintf("average latency went from %.1fms to %.1fms",
+			baseline.AvgMsLatency, candidate.AvgMsLatency), true
+	}
+
+	if candidate.P99MsLatency > baseline.P99MsLatency*(1+latencyRegressionTolerance) {
+		return fmt.Sprintf("p99 latency went from %.1fms to %.1fms",
+			baseline.P99MsLatency, candidate.P99MsLatency), true
+	}
+
+	return "", false
+}
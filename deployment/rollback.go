@@ -0,0 +1,174 @@
+package deployment
+
+import (
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// A hash that recently went live, and is being compared against the last known-good hash
+type regressionWatch struct {
+	hash         string
+	previousHash string
+	liveSince    time.Time
+	done         bool
+}
+
+func (mgr *ActionManager) getRegressionWatch(compPath worker.ComponentPath) (regressionWatch, bool) {
+	mgr.rollbackMux.Lock()
+	defer mgr.rollbackMux.Unlock()
+
+	watch, ok := mgr.regressionWatches[compPath]
+	return watch, ok
+}
+
+func (mgr *ActionManager) setRegressionWatch(compPath worker.ComponentPath, watch regressionWatch) {
+	mgr.rollbackMux.Lock()
+	defer mgr.rollbackMux.Unlock()
+
+	mgr.regressionWatches[compPath] = watch
+	err := mgr.driver.SetRegressionWatch(compPath, database.RegressionWatch{
+		Hash:         watch.hash,
+		PreviousHash: watch.previousHash,
+		LiveSince:    watch.liveSince,
+		Done:         watch.done,
+	})
+	if err != nil {
+		// We are still watching, we would just stop if we restarted now
+		log.Error.Println("Could not persist regression watch of", compPath, ":", err)
+	}
+}
+
+func (mgr *ActionManager) knownGoodHash(compPath worker.ComponentPath) (string, bool) {
+	mgr.rollbackMux.Lock()
+	defer mgr.rollbackMux.Unlock()
+
+	hash, ok := mgr.knownGoodHashes[compPath]
+	return hash, ok
+}
+
+func (mgr *ActionManager) setKnownGoodHash(compPath worker.ComponentPath, hash string) {
+	mgr.rollbackMux.Lock()
+	defer mgr.rollbackMux.Unlock()
+
+	mgr.knownGoodHashes[compPath] = hash
+	err := mgr.driver.SetKnownGoodHash(compPath, hash)
+	if err != nil {
+		log.Error.Println("Could not persist known-good hash", hash, "of", compPath, ":", err)
+	}
+}
+
+// Pick up the regression watches and known-good hashes from before we (re)started, so a hash that went live
+// before a failover still gets rolled back if it regresses
+func (mgr *ActionManager) loadRollbackState() error {
+	watches, err := mgr.driver.FindRegressionWatches()
+	if err != nil {
+		return err
+	}
+	knownGoodHashes, err := mgr.driver.FindKnownGoodHashes()
+	if err != nil {
+		return err
+	}
+
+	mgr.rollbackMux.Lock()
+	defer mgr.rollbackMux.Unlock()
+
+	mgr.regressionWatches = make(map[worker.ComponentPath]regressionWatch, len(watches))
+	for compPath, watch := range watches {
+		mgr.regressionWatches[compPath] = regressionWatch{
+			hash:         watch.Hash,
+			previousHash: watch.PreviousHash,
+			liveSince:    watch.LiveSince,
+			done:         watch.Done,
+		}
+	}
+	mgr.knownGoodHashes = knownGoodHashes
+	log.Info.Println("Loaded", len(watches), "regression watch(es) and", len(knownGoodHashes), "known-good hash(es)")
+	return nil
+}
+
+// Whether the hash is actually serving the component, rather than being a canary or an inactive color
+func (mgr *ActionManager) isLive(compPath worker.ComponentPath, hash string) bool {
+	if _, inCanary := mgr.getCanaryState(compPath); inCanary {
+		return false
+	}
+	if state, ok := mgr.getBlueGreenState(compPath); ok && state.liveHash != hash {
+		return false
+	}
+	return true
+}
+
+// Once a new hash has been live for the watch window, compare it against the last known-good hash,
+// and roll back to that hash if the new one is clearly worse
+func (r *reconciler) watchForRegression(comp database.ActiveComponent) error {
+	// Plans only cover what happens to the workers right now
+	if r.planning {
+		return nil
+	}
+	// Someone chose the pinned hash on purpose, so it is not ours to roll back
+	if _, pinned := r.mgr.pinnedHash(comp.Path); pinned {
+		return nil
+	}
+
+	hash, ok := r.desiredHash(comp.Path)
+	if !ok || hash == headHashSentinel || !r.mgr.isLive(comp.Path, hash) {
+		return nil
+	}
+	now := time.Now()
+
+	watch, watching := r.mgr.getRegressionWatch(comp.Path)
+	if !watching || watch.hash != hash {
+		previousHash, _ := r.mgr.knownGoodHash(comp.Path)
+		if previousHash == hash {
+			// We are back on a known-good hash, so there is nothing to watch
+			r.mgr.setRegressionWatch(comp.Path, regressionWatch{hash: hash, done: true})
+			return nil
+		}
+
+		log.Info.Println("Watching", hash, "of", comp.Path, "for regressions against", previousHash)
+		r.mgr.setRegressionWatch(comp.Path, regressionWatch{hash: hash, previousHash: previousHash, liveSince: now})
+		r.notifyAfter(r.mgr.rollbackWatchWindow)
+		return nil
+	}
+
+	if watch.done || now.Before(watch.liveSince.Add(r.mgr.rollbackWatchWindow)) {
+		return nil
+	}
+	watch.done = true
+	r.mgr.setRegressionWatch(comp.Path, watch)
+
+	// With nothing to compare against, we have to trust it
+	if watch.previousHash == "" {
+		r.mgr.setKnownGoodHash(comp.Path, hash)
+		return nil
+	}
+
+	newHealth, err := r.mgr.driver.FindHashHealth(comp.Path, hash, watch.liveSince, now)
+	if err != nil {
+		return err
+	}
+	// Compare against how the previous hash did in the same amount of time before the switch
+	oldHealth, err := r.mgr.driver.FindHashHealth(
+		comp.Path, watch.previousHash, watch.liveSince.Add(-r.mgr.rollbackWatchWindow), watch.liveSince)
+	if err != nil {
+		return err
+	}
+
+	regression, regressed := findRegression(oldHealth, newHealth)
+	if !regressed {
+		log.Info.Println(hash, "of", comp.Path, "is now known-good")
+		r.mgr.setKnownGoodHash(comp.Path, hash)
+		return nil
+	}
+
+	log.Warning.Println("Rolling back", comp.Path, "from", hash, "to", watch.previousHash, "--", regression)
+	err = r.mgr.driver.MarkBadHash(worker.ComponentID{User: comp.Path.User, Repo: comp.Path.Repo, Hash: hash}, regression)
+	if err != nil {
+		return err
+	}
+	r.setDesiredHash(comp.Path, watch.previousHash)
+	r.mgr.NotifyComponentStateChanged()
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package deployment
+
+import (
+	"sync"
+	"time"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/worker"
+)
+
+// A view of what every worker is running, refreshed once per reconciliation pass
+// and kept up to date as the ActionManager activates and deactivates components
+type ClusterSnapshot struct {
+	mux      sync.Mutex
+	workers  []*worker.V9Worker
+	statuses map[*worker.V9Worker]worker.StatusResponse
+
+	// Replicas on unreachable workers that still count as running, since the workers may come back
+	held map[worker.ComponentID]int
+
+	// What the workers were running before the last refresh, by worker name (including what we changed since)
+	previous map[string]worker.StatusResponse
+
+	// Changes recorded while a refresh is in flight, which are replayed on top of the refreshed statuses
+	refreshing bool
+	journal    []snapshotChange
+}
+
+type snapshotChange struct {
+	w         *worker.V9Worker
+	compID    worker.ComponentID
+	activated bool
+}
+
+func NewClusterSnapshot() *ClusterSnapshot {
+	return &ClusterSnapshot{
+		statuses: make(map[*worker.V9Worker]worker.StatusResponse),
+		held:     make(map[worker.ComponentID]int),
+	}
+}
+
+// Replace the snapshot with the current status of every worker that answers. Workers that don't answer (or that
+// are still backing off after failing to) are left out, so nothing gets placed on them. With a nil tracker, every
+// worker is asked and nothing is recorded.
+func (s *ClusterSnapshot) Refresh(workers []*worker.V9Worker, health *workerHealthTracker) {
+	s.mux.Lock()
+	s.refreshing = true
+	s.journal = nil
+	s.mux.Unlock()
+
+	now := time.Now()
+	reachable := make([]*worker.V9Worker, 0, len(workers))
+	statuses := make(map[*worker.V9Worker]worker.StatusResponse, len(workers))
+	for _, w := range workers {
+		if health != nil && !health.shouldProbe(w, now) {
+			continue
+		}
+
+		status, err := w.Status()
+		if err != nil {
+			if health != nil {
+				health.recordFailure(w, err, now)
+			}
+			continue
+		}
+		if health != nil {
+			health.recordSuccess(w)
+		}
+
+		reachable = append(reachable, w)
+		statuses[w] = status
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.previous = make(map[string]worker.StatusResponse, len(s.statuses))
+	for w, status := range s.statuses {
+		s.previous[w.Name] = status
+	}
+	s.workers = reachable
+	s.statuses = statuses
+	// Anything that happened while we were asking the workers may or may not be in their statuses, so redo it
+	for _, change := range s.journal {
+		s.apply(change)
+	}
+	s.refreshing = false
+	s.journal = nil
+}
+
+// Every reachable worker in the snapshot along with its status
+func (s *ClusterSnapshot) Candidates() []PlacementCandidate {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	candidates := make([]PlacementCandidate, 0, len(s.workers))
+	for _, w := range s.workers {
+		candidates = append(candidates, PlacementCandidate{Worker: w, Status: s.statuses[w]})
+	}
+	return candidates
+}
+
+// Components that were running on a worker before the last refresh, that the worker no longer reports, for workers
+// that answered both times. What we activated ourselves counts as running, so it only shows up here once a worker
+// actually lost it.
+func (s *ClusterSnapshot) Disappeared() []database.RunningComponent {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	disappeared := make([]database.RunningComponent, 0)
+	for _, w := range s.workers {
+		previous, answered := s.previous[w.Name]
+		if !answered {
+			continue
+		}
+		current := s.statuses[w]
+		for _, runningComp := range previous.ActiveComponents {
+			if !current.ContainsExactly(runningComp.ID) {
+				disappeared = append(disappeared, database.RunningComponent{WorkerName: w.Name, ID: runningComp.ID})
+			}
+		}
+	}
+	return disappeared
+}
+
+// Count the components as running, on top of what the reachable workers report (one entry per worker)
+func (s *ClusterSnapshot) SetHeld(compIDs []worker.ComponentID) {
+	held := make(map[worker.ComponentID]int, len(compIDs))
+	for _, compID := range compIDs {
+		held[compID]++
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.held = held
+}
+
+// How many replicas of compID are on unreachable workers, but still count as running
+func (s *ClusterSnapshot) HeldReplicas(compID worker.ComponentID) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.held[compID]
+}
+
+func (s *ClusterSnapshot) RecordActivation(w *worker.V9Worker, compID worker.ComponentID) {
+	s.record(snapshotChange{w: w, compID: compID, activated: true})
+}
+
+func (s *ClusterSnapshot) RecordDeactivation(w *worker.V9Worker, compID worker.ComponentID) {
+	s.record(snapshotChange{w: w, compID: compID, activated: false})
+}
+
+func (s *ClusterSnapshot) record(change snapshotChange) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.refreshing {
+		s.journal = append(s.journal, change)
+	}
+	s.apply(change)
+}
+
+// Must be called with the mutex held. Applying a change twice has the same effect as applying it once.
+func (s *ClusterSnapshot) apply(change snapshotChange) {
+	status, ok := s.statuses[change.w]
+	if !ok {
+		return
+	}
+
+	// Never modify the existing slice, since callers may hold a copy of it
+	activeComponents := make([]worker.ComponentStats, 0, len(status.ActiveComponents)+1)
+	alreadyRunning := false
+	for _, runningComp := range status.ActiveComponents {
+		if runningComp.ID != change.compID {
+			activeComponents = append(activeComponents, runningComp)
+		} else if change.activated {
+			activeComponents = append(activeComponents, runningComp)
+			alreadyRunning = true
+		}
+	}
+	if change.activated && !alreadyRunning {
+		activeComponents = append(activeComponents, worker.ComponentStats{ID: change.compID})
+	}
+
+	status.ActiveComponents = activeComponents
+	s.statuses[change.w] = status
+}
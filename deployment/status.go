@@ -0,0 +1,99 @@
+package deployment
+
+import (
+	"time"
+	"v9_deployment_manager/worker"
+)
+
+// States of a component (failed if it is active, but the last attempt to reconcile it failed)
+const (
+	InactiveComponent = "inactive"
+	ActiveComponent   = "active"
+	FailedComponent   = "failed"
+)
+
+type RunningReplica struct {
+	Worker string `json:"worker"`
+	Hash   string `json:"hash"`
+	Color  string `json:"color,omitempty"`
+}
+
+// What a component should be running, and what it is running
+type ComponentStatus struct {
+	ID              worker.ComponentPath `json:"id"`
+	Active          bool                 `json:"active"`
+	State           string               `json:"state"`
+	Replicas        int                  `json:"replicas,omitempty"`
+	RolloutStrategy string               `json:"rollout_strategy,omitempty"`
+	// Empty if the component follows the repo's default branch
+	TrackedBranch string `json:"tracked_branch,omitempty"`
+	// The hash that was pushed last (which the component runs, unless it is pinned)
+	DesiredHash string           `json:"desired_hash,omitempty"`
+	PinnedHash  string           `json:"pinned_hash,omitempty"`
+	Running     []RunningReplica `json:"running"`
+	// Why the component could not get all its replicas, as of the last time it was reconciled
+	UnplaceableReason string `json:"unplaceable_reason,omitempty"`
+	// How many times in a row it failed, why it failed last, and when it will be tried again
+	Failures  int        `json:"failures,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRetry *time.Time `json:"next_retry,omitempty"`
+}
+
+func (mgr *ActionManager) ComponentStatus(compPath worker.ComponentPath) (ComponentStatus, error) {
+	status := ComponentStatus{
+		ID:      compPath,
+		Running: make([]RunningReplica, 0),
+	}
+
+	active, unreadable, err := mgr.driver.FindActiveComponents()
+	if err != nil {
+		return status, err
+	}
+	for _, activeComp := range active {
+		if activeComp.Path == compPath {
+			status.Active = true
+			status.Replicas = activeComp.Replicas
+			status.RolloutStrategy = activeComp.RolloutStrategy
+		}
+	}
+	for _, unreadableComp := range unreadable {
+		if unreadableComp.Path == compPath {
+			status.Active = true
+		}
+	}
+
+	status.TrackedBranch, err = mgr.driver.FindTrackedBranch(worker.ComponentID{User: compPath.User, Repo: compPath.Repo})
+	if err != nil {
+		return status, err
+	}
+
+	status.DesiredHash, _ = mgr.desiredHash(compPath)
+	status.PinnedHash, _ = mgr.pinnedHash(compPath)
+	status.UnplaceableReason, _ = mgr.unplaceableReason(compPath)
+
+	status.State = InactiveComponent
+	if status.Active {
+		status.State = ActiveComponent
+	}
+	if failure, failing := mgr.componentFailure(compPath); failing && status.Active {
+		status.State = FailedComponent
+		status.Failures = failure.consecutiveFailures
+		status.LastError = failure.lastErr.Error()
+		status.NextRetry = &failure.nextRetry
+	}
+
+	// As of the last reconciliation pass
+	for _, candidate := range mgr.snapshot.Candidates() {
+		for _, runningComp := range candidate.Status.ActiveComponents {
+			if runningComp.ID.User == compPath.User && runningComp.ID.Repo == compPath.Repo {
+				status.Running = append(status.Running, RunningReplica{
+					Worker: candidate.Worker.Name,
+					Hash:   runningComp.ID.Hash,
+					Color:  runningComp.Color,
+				})
+			}
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,144 @@
+package deployment
+
+import (
+	"sync"
+	"time"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+const (
+	HealthyWorker = "healthy"
+	SuspectWorker = "suspect"
+	DownWorker    = "down"
+)
+
+// How many status checks in a row have to fail before a worker is considered down
+const workerDownThreshold = 3
+
+// How long to wait before probing a failing worker again, doubling with every failure up to the max
+const workerProbeBackoff = 5 * time.Second
+const workerMaxProbeBackoff = 5 * time.Minute
+
+type workerHealth struct {
+	consecutiveFailures int
+	lastErr             error
+	nextProbe           time.Time
+	// When the worker first failed, in the current run of failures
+	failingSince time.Time
+}
+
+func (health workerHealth) state() string {
+	switch {
+	case health.consecutiveFailures == 0:
+		return HealthyWorker
+	case health.consecutiveFailures < workerDownThreshold:
+		return SuspectWorker
+	default:
+		return DownWorker
+	}
+}
+
+// Keeps track of which workers answer their status checks, so one broken worker doesn't hold up the rest
+type workerHealthTracker struct {
+	mux    sync.Mutex
+	health map[string]workerHealth
+}
+
+func newWorkerHealthTracker() *workerHealthTracker {
+	return &workerHealthTracker{
+		health: make(map[string]workerHealth),
+	}
+}
+
+func (t *workerHealthTracker) get(w *worker.V9Worker) workerHealth {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.health[w.Name]
+}
+
+// Whether the worker should be asked for its status now, or left alone until its backoff is over
+func (t *workerHealthTracker) shouldProbe(w *worker.V9Worker, now time.Time) bool {
+	return !now.Before(t.get(w).nextProbe)
+}
+
+func (t *workerHealthTracker) recordSuccess(w *worker.V9Worker) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if previous := t.health[w.Name]; previous.consecutiveFailures > 0 {
+		log.Info.Println("Worker", w.Name, "is", HealthyWorker, "again after", previous.consecutiveFailures,
+			"failed check(s)")
+	}
+	t.health[w.Name] = workerHealth{}
+}
+
+func (t *workerHealthTracker) recordFailure(w *worker.V9Worker, err error, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	health := t.health[w.Name]
+	previousState := health.state()
+	if health.consecutiveFailures == 0 {
+		health.failingSince = now
+	}
+	health.consecutiveFailures++
+	health.lastErr = err
+
+	backoff := workerProbeBackoff
+	for i := 1; i < health.consecutiveFailures && backoff < workerMaxProbeBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > workerMaxProbeBackoff {
+		backoff = workerMaxProbeBackoff
+	}
+	health.nextProbe = now.Add(backoff)
+	t.health[w.Name] = health
+
+	if health.state() != previousState {
+		log.Warning.Println("Worker", w.Name, "is now", health.state(), "--", err)
+	}
+	log.Warning.Println("Could not get the status of worker", w.Name, "-- probing again in", backoff)
+}
+
+// When each failing worker started failing, by name
+func (t *workerHealthTracker) failing() map[string]time.Time {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	failing := make(map[string]time.Time)
+	for name, health := range t.health {
+		if health.consecutiveFailures > 0 {
+			failing[name] = health.failingSince
+		}
+	}
+	return failing
+}
+
+// The earliest time a failing worker is due to be probed again (false if every worker is healthy)
+func (t *workerHealthTracker) nextProbe() (time.Time, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	var next time.Time
+	found := false
+	for _, health := range t.health {
+		if health.consecutiveFailures > 0 && (!found || health.nextProbe.Before(next)) {
+			next = health.nextProbe
+			found = true
+		}
+	}
+	return next, found
+}
+
+// Probe the worker on the next pass, even if it is still backing off
+func (t *workerHealthTracker) probeNow(w *worker.V9Worker) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if health, ok := t.health[w.Name]; ok {
+		health.nextProbe = time.Time{}
+		t.health[w.Name] = health
+	}
+}
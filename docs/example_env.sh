@@ -1,3 +1,4 @@
+# Optional: workers that don't register themselves
 export V9_WORKERS='<worker.url.1>;<worker.url.2>'
 export V9_PG_HOST='<pg.host.url>'
 export V9_PG_PORT=<pg_port>
@@ -5,5 +6,34 @@ export V9_PG_USER=v9_sys
 export V9_PG_PASSWORD=<PG PASSWORD>
 export V9_PG_DB=v9
 export GITHUB_SECRET=<GITHUB SECRET>
+# Optional: random (default), least-loaded, fewest-components or round-robin
+export V9_PLACEMENT_STRATEGY=least-loaded
+# Optional: how many components can be reconciled at once (default 4)
+export V9_RECONCILE_CONCURRENCY=4
+# Optional: how long blue/green deployments keep the old color around (default 10m)
+export V9_BLUE_GREEN_RETENTION=10m
+# Optional: how long canaries are observed before being promoted or aborted (default 10m)
+export V9_CANARY_WINDOW=10m
+# Optional: how long new hashes are watched for regressions before they are trusted (default 10m)
+export V9_ROLLBACK_WATCH_WINDOW=10m
+# Optional: how often to reconcile and check for drift without being told to (default 1m)
+export V9_RECONCILE_INTERVAL=1m
+# Optional: how long a worker can be unreachable before its components are moved to other workers (default 2m)
+export V9_FAILOVER_GRACE_PERIOD=2m
+# Optional: how long registered workers can go without a heartbeat before they count as down (default 30s)
+export V9_WORKER_HEARTBEAT_TIMEOUT=30s
+# Optional: the secret workers send in the X-V9-Worker-Secret header to register and send heartbeats (without it,
+# only V9_WORKERS are used)
+export V9_WORKER_SECRET=<WORKER SECRET>
+# Where other instances can reach this one when it is the leader, so they can forward requests to it
+export V9_ADVERTISE_URL=http://<this.instance.url>:81
+# Optional: how long to wait for more pushes to a component before deploying the newest one, 0s to disable (default 15s)
+export V9_DEBOUNCE_WINDOW=15s
+# Optional: how often to scale autoscaled components to their traffic (default 30s)
+export V9_AUTOSCALE_INTERVAL=30s
+# Optional: how long after scaling a component it can be scaled up again (default 3m)
+export V9_SCALE_UP_COOLDOWN=3m
+# Optional: how long after scaling a component it can be scaled down again (default 10m)
+export V9_SCALE_DOWN_COOLDOWN=10m
 
 
@@ -0,0 +1,124 @@
+-- Tables and columns the deployment manager needs on top of the base v9 schema (users, components, workers,
+-- stats, logs, currently_running and deploying). Every statement can be run again safely, so apply the whole file
+-- before starting a new version of the deployment manager:
+--
+--     psql -h $V9_PG_HOST -p $V9_PG_PORT -U $V9_PG_USER -d $V9_PG_DB -f docs/schema_changes.sql
+--
+-- IDs are UUIDs, like the rest of the v9 schema.
+
+-- How each component is deployed
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS replicas INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS rollout_strategy TEXT NOT NULL DEFAULT 'replace';
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS required_labels JSONB;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS anti_affinity TEXT;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS desired_hash TEXT;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS pinned_hash TEXT;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS known_good_hash TEXT;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS tracked_branch TEXT;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS autoscale_min_replicas INTEGER;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS autoscale_max_replicas INTEGER;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS autoscale_target_rps DOUBLE PRECISION;
+ALTER TABLE v9.public.components ADD COLUMN IF NOT EXISTS last_scaled_time TIMESTAMPTZ;
+
+-- Which hash the stats and logs came from, and when
+ALTER TABLE v9.public.stats ADD COLUMN IF NOT EXISTS hash TEXT;
+ALTER TABLE v9.public.stats ADD COLUMN IF NOT EXISTS received_time TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE v9.public.logs ADD COLUMN IF NOT EXISTS hash TEXT;
+CREATE INDEX IF NOT EXISTS stats_component_received_time ON v9.public.stats(component_id, received_time);
+CREATE INDEX IF NOT EXISTS logs_component_received_time ON v9.public.logs(component_id, received_time);
+
+-- Workers that register themselves
+ALTER TABLE v9.public.workers ADD COLUMN IF NOT EXISTS worker_url TEXT;
+ALTER TABLE v9.public.workers ADD COLUMN IF NOT EXISTS labels JSONB;
+ALTER TABLE v9.public.workers ADD COLUMN IF NOT EXISTS last_heartbeat TIMESTAMPTZ;
+
+-- Workers nothing new is placed on, and workers everything is being moved off (draining workers are also cordoned)
+ALTER TABLE v9.public.workers ADD COLUMN IF NOT EXISTS cordoned BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE v9.public.workers ADD COLUMN IF NOT EXISTS draining BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Hashes that were rolled back, and must not be deployed again
+CREATE TABLE IF NOT EXISTS v9.public.bad_hashes (
+    component_id UUID NOT NULL REFERENCES v9.public.components(component_id) ON DELETE CASCADE,
+    hash TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    marked_time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (component_id, hash)
+);
+
+-- New hashes that are being watched for regressions against the known-good hash
+CREATE TABLE IF NOT EXISTS v9.public.regression_watches (
+    component_id UUID PRIMARY KEY REFERENCES v9.public.components(component_id) ON DELETE CASCADE,
+    hash TEXT NOT NULL,
+    previous_hash TEXT,
+    live_since TIMESTAMPTZ NOT NULL,
+    done BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Which color of each blue/green component is live, and what is kept around to flip back to
+CREATE TABLE IF NOT EXISTS v9.public.blue_green_states (
+    component_id UUID PRIMARY KEY REFERENCES v9.public.components(component_id) ON DELETE CASCADE,
+    live_hash TEXT NOT NULL,
+    live_color TEXT NOT NULL,
+    previous_hash TEXT,
+    previous_color TEXT,
+    retain_until TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS v9.public.deployment_history (
+    deployment_id BIGSERIAL PRIMARY KEY,
+    component_id UUID NOT NULL REFERENCES v9.public.components(component_id) ON DELETE CASCADE,
+    hash TEXT NOT NULL,
+    action TEXT NOT NULL,
+    worker_url TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    start_time TIMESTAMPTZ NOT NULL,
+    end_time TIMESTAMPTZ NOT NULL,
+    phase_durations_ms JSONB NOT NULL,
+    outcome TEXT NOT NULL,
+    error TEXT
+);
+CREATE INDEX IF NOT EXISTS deployment_history_component_start_time
+    ON v9.public.deployment_history(component_id, start_time);
+
+-- Drift, failovers and anything else notable that happened to a component
+CREATE TABLE IF NOT EXISTS v9.public.events (
+    event_id BIGSERIAL PRIMARY KEY,
+    component_id UUID NOT NULL REFERENCES v9.public.components(component_id) ON DELETE CASCADE,
+    worker_name TEXT,
+    event_type TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    event_time TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Where followers forward requests to
+CREATE TABLE IF NOT EXISTS v9.public.manager_leader (
+    lock_key BIGINT PRIMARY KEY,
+    leader_url TEXT NOT NULL,
+    elected_time TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- What each user may use. The row without a user is the default for everyone else, and NULL limits are unlimited.
+CREATE TABLE IF NOT EXISTS v9.public.quotas (
+    quota_id BIGSERIAL PRIMARY KEY,
+    user_id UUID UNIQUE REFERENCES v9.public.users(user_id) ON DELETE CASCADE,
+    max_active_components INTEGER,
+    max_replicas INTEGER,
+    max_builds_per_hour INTEGER
+);
+
+-- UNIQUE lets any number of rows have a NULL user, but there can only be one default
+CREATE UNIQUE INDEX IF NOT EXISTS quotas_single_default ON v9.public.quotas ((user_id IS NULL)) WHERE user_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS v9.public.scaling_decisions (
+    decision_id BIGSERIAL PRIMARY KEY,
+    component_id UUID NOT NULL REFERENCES v9.public.components(component_id) ON DELETE CASCADE,
+    from_replicas INTEGER NOT NULL,
+    to_replicas INTEGER NOT NULL,
+    requests_per_second DOUBLE PRECISION NOT NULL,
+    target_requests_per_second DOUBLE PRECISION NOT NULL,
+    samples INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    decision_time TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS scaling_decisions_component_decision_time
+    ON v9.public.scaling_decisions(component_id, decision_time);
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"v9_deployment_manager/database"
 	"v9_deployment_manager/deployment"
 	"v9_deployment_manager/log"
@@ -44,6 +46,12 @@ func (h *SetDeploymentIntentionHandler) ServeHTTP(w http.ResponseWriter, r *http
 		return
 	}
 	log.Info.Println(p.ID, p.NewDeploymentIntention)
+	if p.NewDeploymentIntention == "active" {
+		err = h.driver.CheckActivationQuota(p.ID)
+		if !writeQuotaError(w, err) {
+			return
+		}
+	}
 	// Update Database
 	err = h.driver.SetDeploymentIntention(p.ID, p.NewDeploymentIntention)
 	if err != nil {
@@ -59,3 +67,442 @@ func (h *SetDeploymentIntentionHandler) ServeHTTP(w http.ResponseWriter, r *http
 		log.Error.Println("Failed to write back that everything worked", err)
 	}
 }
+
+// Write back why the quota check failed. Returns whether it passed.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var quotaErr *database.QuotaError
+	if errors.As(err, &quotaErr) {
+		log.Info.Println("Rejected request", quotaErr)
+		http.Error(w, quotaErr.Error(), http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		log.Error.Println("Failed to check quota", err)
+		http.Error(w, "could not check quota", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+type SetReplicasHandler struct {
+	actionManager *deployment.ActionManager
+	driver        *database.Driver
+}
+
+type SetReplicasBody struct {
+	ID       worker.ComponentPath `json:"id"`
+	Replicas int                  `json:"replicas"`
+}
+
+func NewSetReplicasHandler(
+	actionManager *deployment.ActionManager,
+	driver *database.Driver) *SetReplicasHandler {
+	return &SetReplicasHandler{
+		actionManager: actionManager,
+		driver:        driver,
+	}
+}
+
+func (h *SetReplicasHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p SetReplicasBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	log.Info.Println(p.ID, p.Replicas)
+	if p.Replicas < 1 {
+		http.Error(w, "replicas must be at least 1", http.StatusBadRequest)
+		return
+	}
+	err = h.driver.CheckReplicasQuota(p.ID, p.Replicas)
+	if !writeQuotaError(w, err) {
+		return
+	}
+	// Update Database
+	err = h.driver.SetReplicas(p.ID, p.Replicas)
+	if err != nil {
+		log.Error.Println("Failed to update replicas on database", err)
+		return
+	}
+	// Notify Action Manager
+	h.actionManager.NotifyComponentStateChanged()
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type SetRolloutStrategyHandler struct {
+	actionManager *deployment.ActionManager
+	driver        *database.Driver
+}
+
+type SetRolloutStrategyBody struct {
+	ID              worker.ComponentPath `json:"id"`
+	RolloutStrategy string               `json:"rollout_strategy"`
+}
+
+func NewSetRolloutStrategyHandler(
+	actionManager *deployment.ActionManager,
+	driver *database.Driver) *SetRolloutStrategyHandler {
+	return &SetRolloutStrategyHandler{
+		actionManager: actionManager,
+		driver:        driver,
+	}
+}
+
+func (h *SetRolloutStrategyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p SetRolloutStrategyBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	log.Info.Println(p.ID, p.RolloutStrategy)
+	switch p.RolloutStrategy {
+	case database.ReplaceRollout, database.BlueGreenRollout, database.CanaryRollout:
+	default:
+		http.Error(w, "unknown rollout strategy "+p.RolloutStrategy, http.StatusBadRequest)
+		return
+	}
+	// Update Database
+	err = h.driver.SetRolloutStrategy(p.ID, p.RolloutStrategy)
+	if err != nil {
+		log.Error.Println("Failed to update rollout strategy on database", err)
+		return
+	}
+	// Notify Action Manager
+	h.actionManager.NotifyComponentStateChanged()
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type FlipBlueGreenHandler struct {
+	actionManager *deployment.ActionManager
+}
+
+type FlipBlueGreenBody struct {
+	ID worker.ComponentPath `json:"id"`
+}
+
+func NewFlipBlueGreenHandler(actionManager *deployment.ActionManager) *FlipBlueGreenHandler {
+	return &FlipBlueGreenHandler{
+		actionManager: actionManager,
+	}
+}
+
+func (h *FlipBlueGreenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p FlipBlueGreenBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	// Flip back to the previous color
+	err = h.actionManager.FlipBlueGreen(p.ID)
+	if err != nil {
+		log.Error.Println("Failed to flip blue/green deployment", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+var commitHashPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+type SetDeploymentHashHandler struct {
+	actionManager *deployment.ActionManager
+}
+
+type SetDeploymentHashBody struct {
+	ID   worker.ComponentPath `json:"id"`
+	Hash string               `json:"hash"`
+}
+
+func NewSetDeploymentHashHandler(actionManager *deployment.ActionManager) *SetDeploymentHashHandler {
+	return &SetDeploymentHashHandler{
+		actionManager: actionManager,
+	}
+}
+
+func (h *SetDeploymentHashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p SetDeploymentHashBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	log.Info.Println(p.ID, p.Hash)
+	// Workers report the full hash they run, so anything shorter would never look deployed
+	if !commitHashPattern.MatchString(p.Hash) {
+		http.Error(w, "hash must be a full commit hash (40 lowercase hex characters)", http.StatusBadRequest)
+		return
+	}
+	// Pin the component (which updates the database)
+	err = h.actionManager.PinComponent(p.ID, p.Hash)
+	if errors.Is(err, deployment.ErrRolledBackHash) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, deployment.ErrUnknownHash) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error.Println("Failed to pin component", err)
+		http.Error(w, "could not pin component", http.StatusInternalServerError)
+		return
+	}
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type UnpinDeploymentHashHandler struct {
+	actionManager *deployment.ActionManager
+}
+
+type UnpinDeploymentHashBody struct {
+	ID worker.ComponentPath `json:"id"`
+}
+
+func NewUnpinDeploymentHashHandler(actionManager *deployment.ActionManager) *UnpinDeploymentHashHandler {
+	return &UnpinDeploymentHashHandler{
+		actionManager: actionManager,
+	}
+}
+
+func (h *UnpinDeploymentHashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p UnpinDeploymentHashBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	// Unpin the component (which updates the database)
+	err = h.actionManager.UnpinComponent(p.ID)
+	if err != nil {
+		log.Error.Println("Failed to unpin component", err)
+		http.Error(w, "could not unpin component", http.StatusInternalServerError)
+		return
+	}
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type SetTrackedBranchHandler struct {
+	actionManager *deployment.ActionManager
+	driver        *database.Driver
+}
+
+type SetTrackedBranchBody struct {
+	ID     worker.ComponentPath `json:"id"`
+	Branch string               `json:"branch"`
+}
+
+func NewSetTrackedBranchHandler(
+	actionManager *deployment.ActionManager,
+	driver *database.Driver) *SetTrackedBranchHandler {
+	return &SetTrackedBranchHandler{
+		actionManager: actionManager,
+		driver:        driver,
+	}
+}
+
+func (h *SetTrackedBranchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p SetTrackedBranchBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	log.Info.Println(p.ID, p.Branch)
+	// Update Database
+	err = h.driver.SetTrackedBranch(p.ID, p.Branch)
+	if err != nil {
+		log.Error.Println("Failed to update tracked branch on database", err)
+		return
+	}
+	// Deploy the head of the new branch, until something gets pushed to it
+	h.actionManager.UpdateComponentHash(worker.ComponentID{User: p.ID.User, Repo: p.ID.Repo, Hash: "HEAD"})
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type SetPlacementConstraintsHandler struct {
+	actionManager *deployment.ActionManager
+	driver        *database.Driver
+}
+
+type SetPlacementConstraintsBody struct {
+	ID             worker.ComponentPath `json:"id"`
+	RequiredLabels map[string]string    `json:"required_labels"`
+	AntiAffinity   string               `json:"anti_affinity"`
+}
+
+func NewSetPlacementConstraintsHandler(
+	actionManager *deployment.ActionManager,
+	driver *database.Driver) *SetPlacementConstraintsHandler {
+	return &SetPlacementConstraintsHandler{
+		actionManager: actionManager,
+		driver:        driver,
+	}
+}
+
+func (h *SetPlacementConstraintsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p SetPlacementConstraintsBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	log.Info.Println(p.ID, p.RequiredLabels, p.AntiAffinity)
+	// Update Database
+	err = h.driver.SetPlacementConstraints(p.ID, p.RequiredLabels, p.AntiAffinity)
+	if err != nil {
+		log.Error.Println("Failed to update placement constraints on database", err)
+		return
+	}
+	// Notify Action Manager
+	h.actionManager.NotifyComponentStateChanged()
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type SetAutoscalingHandler struct {
+	actionManager *deployment.ActionManager
+	driver        *database.Driver
+}
+
+type SetAutoscalingBody struct {
+	ID worker.ComponentPath `json:"id"`
+	database.AutoscalingConfig
+}
+
+func NewSetAutoscalingHandler(
+	actionManager *deployment.ActionManager,
+	driver *database.Driver) *SetAutoscalingHandler {
+	return &SetAutoscalingHandler{
+		actionManager: actionManager,
+		driver:        driver,
+	}
+}
+
+func (h *SetAutoscalingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p SetAutoscalingBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	log.Info.Println(p.ID, p.AutoscalingConfig)
+	// All zeroes turns autoscaling off
+	if p.AutoscalingConfig != (database.AutoscalingConfig{}) {
+		if p.MinReplicas < 1 || p.MaxReplicas < p.MinReplicas {
+			http.Error(w, "min_replicas must be at least 1, and max_replicas at least min_replicas", http.StatusBadRequest)
+			return
+		}
+		if p.TargetRequestsPerSecond <= 0 {
+			http.Error(w, "target_requests_per_second must be positive", http.StatusBadRequest)
+			return
+		}
+		// The component is scaled up to the minimum right away
+		err = h.driver.CheckReplicasQuota(p.ID, p.MinReplicas)
+		if !writeQuotaError(w, err) {
+			return
+		}
+	}
+	// Update Database
+	err = h.driver.SetAutoscaling(p.ID, p.AutoscalingConfig)
+	if err != nil {
+		log.Error.Println("Failed to update autoscaling on database", err)
+		return
+	}
+	// Tell the Action Manager
+	err = h.actionManager.AutoscaleComponent(p.ID)
+	if err != nil {
+		// It is tried again on the next autoscaling tick
+		log.Error.Println("Failed to autoscale", p.ID, err)
+	}
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
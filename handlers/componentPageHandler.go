@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+const defaultPerPage = 20
+const maxPerPage = 100
+
+// A page of what happened to one component, as asked for with ?user=&repo=&page=&per_page=
+type componentPage struct {
+	compPath worker.ComponentPath
+	page     int
+	perPage  int
+}
+
+// How many records come before the page
+func (p componentPage) offset() int {
+	return (p.page - 1) * p.perPage
+}
+
+// Parse a positive integer query parameter, falling back to the default if it is missing
+func positiveQueryParam(r *http.Request, name string, defaultVal int) (int, bool) {
+	valString := r.URL.Query().Get(name)
+	if valString == "" {
+		return defaultVal, true
+	}
+
+	val, err := strconv.Atoi(valString)
+	if err != nil || val < 1 {
+		return 0, false
+	}
+	return val, true
+}
+
+// Parse the page a GET request asks for. Returns false, after writing the error, if it isn't a valid one.
+func parseComponentPage(w http.ResponseWriter, r *http.Request) (componentPage, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return componentPage{}, false
+	}
+
+	compPath := worker.ComponentPath{
+		User: r.URL.Query().Get("user"),
+		Repo: r.URL.Query().Get("repo"),
+	}
+	if compPath.User == "" || compPath.Repo == "" {
+		http.Error(w, "user and repo are required", http.StatusBadRequest)
+		return componentPage{}, false
+	}
+	page, ok := positiveQueryParam(r, "page", 1)
+	if !ok {
+		http.Error(w, "page must be a positive integer", http.StatusBadRequest)
+		return componentPage{}, false
+	}
+	perPage, ok := positiveQueryParam(r, "per_page", defaultPerPage)
+	if !ok || perPage > maxPerPage {
+		http.Error(w, "per_page must be between 1 and "+strconv.Itoa(maxPerPage), http.StatusBadRequest)
+		return componentPage{}, false
+	}
+
+	return componentPage{compPath: compPath, page: page, perPage: perPage}, true
+}
+
+// Handles GET requests for a page of what happened to a component, responding with whatever find gives for the page
+type ComponentPageHandler struct {
+	what string
+	find func(page componentPage) (interface{}, error)
+}
+
+func (h *ComponentPageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse query
+	page, ok := parseComponentPage(w, r)
+	if !ok {
+		return
+	}
+
+	// Query Database
+	response, err := h.find(page)
+	if err != nil {
+		log.Error.Println("Failed to get", h.what, "from database", err)
+		http.Error(w, "could not get "+h.what, http.StatusInternalServerError)
+		return
+	}
+
+	// Send Response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Error.Println("Failed to write", h.what, err)
+	}
+}
+
+type DeploymentHistoryResponse struct {
+	Deployments []database.DeploymentRecord `json:"deployments"`
+	Page        int                         `json:"page"`
+	PerPage     int                         `json:"per_page"`
+}
+
+// Handles GET /api/deployments?user=&repo=&page=&per_page=
+func NewDeploymentHistoryHandler(driver *database.Driver) *ComponentPageHandler {
+	return &ComponentPageHandler{
+		what: "deployment history",
+		find: func(page componentPage) (interface{}, error) {
+			deployments, err := driver.FindDeploymentHistory(page.compPath, page.perPage, page.offset())
+			return DeploymentHistoryResponse{Deployments: deployments, Page: page.page, PerPage: page.perPage}, err
+		},
+	}
+}
+
+type ScalingDecisionsResponse struct {
+	Decisions []database.ScalingDecision `json:"decisions"`
+	Page      int                        `json:"page"`
+	PerPage   int                        `json:"per_page"`
+}
+
+// Handles GET /api/scaling_decisions?user=&repo=&page=&per_page=
+func NewScalingDecisionsHandler(driver *database.Driver) *ComponentPageHandler {
+	return &ComponentPageHandler{
+		what: "scaling decisions",
+		find: func(page componentPage) (interface{}, error) {
+			decisions, err := driver.FindScalingDecisions(page.compPath, page.perPage, page.offset())
+			return ScalingDecisionsResponse{Decisions: decisions, Page: page.page, PerPage: page.perPage}, err
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"v9_deployment_manager/deployment"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+type ComponentStatusHandler struct {
+	actionManager *deployment.ActionManager
+}
+
+func NewComponentStatusHandler(actionManager *deployment.ActionManager) *ComponentStatusHandler {
+	return &ComponentStatusHandler{
+		actionManager: actionManager,
+	}
+}
+
+func (h *ComponentStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query
+	compPath := worker.ComponentPath{
+		User: r.URL.Query().Get("user"),
+		Repo: r.URL.Query().Get("repo"),
+	}
+	if compPath.User == "" || compPath.Repo == "" {
+		http.Error(w, "user and repo are required", http.StatusBadRequest)
+		return
+	}
+
+	// Work out the status
+	status, err := h.actionManager.ComponentStatus(compPath)
+	if err != nil {
+		log.Error.Println("Failed to get component status", err)
+		http.Error(w, "could not get component status", http.StatusInternalServerError)
+		return
+	}
+
+	// Send Response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Error.Println("Failed to write component status", err)
+	}
+}
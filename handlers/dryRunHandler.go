@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// Serves what a dry run can answer (like the plan), and refuses anything that would change what the leader does
+type DryRunHandler struct {
+	next http.Handler
+}
+
+func NewDryRunHandler(next http.Handler) *DryRunHandler {
+	return &DryRunHandler{
+		next: next,
+	}
+}
+
+func (h *DryRunHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "this is a dry run, send changes to the leader", http.StatusMethodNotAllowed)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+)
+
+// Serves requests on the leader, and forwards them to the leader everywhere else
+type LeaderForwarder struct {
+	election *database.LeaderElection
+	driver   *database.Driver
+	next     http.Handler
+	// Requests to these paths are served here when the leader can't be reached, rather than dropped
+	recordedPaths map[string]bool
+}
+
+func NewLeaderForwarder(
+	election *database.LeaderElection,
+	driver *database.Driver,
+	next http.Handler,
+	recordedPaths []string) *LeaderForwarder {
+	recorded := make(map[string]bool, len(recordedPaths))
+	for _, path := range recordedPaths {
+		recorded[path] = true
+	}
+
+	return &LeaderForwarder{
+		election:      election,
+		driver:        driver,
+		next:          next,
+		recordedPaths: recorded,
+	}
+}
+
+func (h *LeaderForwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.election.IsLeader() {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	// Keep the body around, so the request can still be served here if forwarding it fails
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read the request", http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Find the leader
+	leaderURL, err := h.driver.FindLeaderURL()
+	if err != nil {
+		log.Error.Println("Failed to find the leader", err)
+		h.serveWithoutLeader(w, r, body, http.StatusServiceUnavailable, "could not find the leader")
+		return
+	}
+	if leaderURL == "" {
+		h.serveWithoutLeader(w, r, body, http.StatusServiceUnavailable, "there is no leader to forward to")
+		return
+	}
+	target, err := url.Parse(leaderURL)
+	if err != nil {
+		log.Error.Println("Failed to parse leader url", leaderURL, err)
+		h.serveWithoutLeader(w, r, body, http.StatusServiceUnavailable, "could not find the leader")
+		return
+	}
+
+	// Forward the request as is
+	log.Info.Println("Forwarding", r.URL.Path, "to the leader at", leaderURL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+		log.Error.Println("Failed to forward to the leader", err)
+		h.serveWithoutLeader(w, r, body, http.StatusBadGateway, "could not reach the leader")
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// Recorded requests (like pushes, which nobody sends again) are served here for the next leader to pick up.
+// Everything else fails, so whoever sent it knows to try again.
+func (h *LeaderForwarder) serveWithoutLeader(
+	w http.ResponseWriter,
+	r *http.Request,
+	body []byte,
+	status int,
+	message string) {
+	if !h.recordedPaths[r.URL.Path] {
+		http.Error(w, message, status)
+		return
+	}
+
+	log.Warning.Println("Recording", r.URL.Path, "for the next leader --", message)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"v9_deployment_manager/deployment"
+	"v9_deployment_manager/log"
+)
+
+type MetricsHandler struct {
+	actionManager *deployment.ActionManager
+}
+
+func NewMetricsHandler(actionManager *deployment.ActionManager) *MetricsHandler {
+	return &MetricsHandler{
+		actionManager: actionManager,
+	}
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Send Response
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(h.actionManager.Metrics())
+	if err != nil {
+		log.Error.Println("Failed to write metrics", err)
+	}
+}
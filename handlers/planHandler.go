@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"v9_deployment_manager/deployment"
+	"v9_deployment_manager/log"
+)
+
+type PlanHandler struct {
+	actionManager *deployment.ActionManager
+}
+
+func NewPlanHandler(actionManager *deployment.ActionManager) *PlanHandler {
+	return &PlanHandler{
+		actionManager: actionManager,
+	}
+}
+
+func (h *PlanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Work out the plan
+	plan, err := h.actionManager.Plan()
+	if err != nil {
+		log.Error.Println("Failed to plan reconciliation", err)
+		http.Error(w, "could not plan reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	// Send Response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(plan)
+	if err != nil {
+		log.Error.Println("Failed to write plan", err)
+	}
+}
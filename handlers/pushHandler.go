@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"os"
+	"strings"
 	"v9_deployment_manager/database"
 	"v9_deployment_manager/deployment"
 	"v9_deployment_manager/log"
@@ -11,6 +12,8 @@ import (
 	"github.com/hjaensch7/webhooks/github"
 )
 
+const branchRefPrefix = "refs/heads/"
+
 type PushHandler struct {
 	actionManager *deployment.ActionManager
 	driver        *database.Driver
@@ -26,8 +29,6 @@ func NewPushHandler(actionManager *deployment.ActionManager, driver *database.Dr
 }
 
 func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// TODO: Ensure we only deploy from master
-
 	// Load secret from env
 	secret, exists := os.LookupEnv("GITHUB_SECRET")
 	if !exists {
@@ -41,7 +42,8 @@ func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	// Parse push event or installation event from webhook
 	// Note: integration events from github are ignored
-	payload, err := hook.Parse(r, github.PushEvent, github.InstallationEvent, github.InstallationRepositoriesEvent)
+	payload, err := hook.Parse(r, github.PushEvent, github.PullRequestEvent,
+		github.InstallationEvent, github.InstallationRepositoriesEvent)
 	if err != nil {
 		log.Error.Println("github payload parse error:", err)
 		return
@@ -49,6 +51,7 @@ func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Declare repo info vars
 	var user string
 	var repo string
+	// Installations deploy the head of the tracked branch, pushes deploy exactly what was pushed
 	const hash = "HEAD"
 	// Send to Installation Handler if needed
 	switch payload := payload.(type) {
@@ -66,15 +69,103 @@ func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			compID := worker.ComponentID{User: user, Repo: repo.Name, Hash: hash}
 			h.processComponentEvent(compID)
 		}
+	case github.PullRequestPayload:
+		log.Info.Println("Received Github PullRequest Event...")
+		h.processPullRequestEvent(payload)
 	default:
 		parsedPayload := payload.(github.PushPayload)
 		user = parsedPayload.Repository.Owner.Login
 		repo = parsedPayload.Repository.Name
-		// NOTE: We don't know that the branch is correct, so we can't trust the head commit
-		//hash = parsedPayload.HeadCommit.ID
 
-		compID := worker.ComponentID{User: user, Repo: repo, Hash: hash}
-		h.processComponentEvent(compID)
+		compID := worker.ComponentID{User: user, Repo: repo, Hash: parsedPayload.HeadCommit.ID}
+		if h.isTrackedPush(compID, parsedPayload) {
+			h.processComponentEvent(compID)
+		}
+	}
+}
+
+// Whether the push is to the branch the component is deployed from (and has a commit to deploy)
+func (h *PushHandler) isTrackedPush(compID worker.ComponentID, payload github.PushPayload) bool {
+	if !strings.HasPrefix(payload.Ref, branchRefPrefix) {
+		log.Info.Println("Ignoring push to", payload.Ref, "of", compID.User+"/"+compID.Repo, "since it is not a branch")
+		return false
+	}
+	branch := strings.TrimPrefix(payload.Ref, branchRefPrefix)
+
+	trackedBranch, err := h.driver.FindTrackedBranch(compID)
+	if err != nil {
+		log.Error.Println("Error finding tracked branch", err)
+		return false
+	}
+	if trackedBranch == "" {
+		trackedBranch = payload.Repository.DefaultBranch
+	}
+
+	if branch != trackedBranch {
+		log.Info.Println("Ignoring push to", branch, "of", compID.User+"/"+compID.Repo, "since it tracks", trackedBranch)
+		return false
+	}
+	if payload.Deleted || compID.Hash == "" {
+		log.Info.Println("Ignoring push to", branch, "of", compID.User+"/"+compID.Repo, "since it has no head commit")
+		return false
+	}
+	return true
+}
+
+// Keep a preview component running the head of every open pull request to an active component
+func (h *PushHandler) processPullRequestEvent(payload github.PullRequestPayload) {
+	basePath := worker.ComponentPath{User: payload.Repository.Owner.Login, Repo: payload.Repository.Name}
+	previewPath := worker.ComponentPath{User: basePath.User, Repo: worker.PreviewRepo(basePath.Repo, payload.Number)}
+
+	switch payload.Action {
+	case "opened", "reopened", "synchronize":
+		intention, err := h.driver.FindDeploymentIntention(basePath)
+		if err != nil {
+			log.Error.Println("Error finding deployment intention", err)
+			return
+		}
+		if intention != "active" {
+			log.Info.Println("Not previewing", previewPath, "since", basePath, "is not active")
+			return
+		}
+
+		compID := worker.ComponentID{User: previewPath.User, Repo: previewPath.Repo, Hash: payload.PullRequest.Head.Sha}
+		_, err = h.driver.FindComponentID(compID)
+		if err != nil {
+			log.Error.Println("Error finding database component id", err)
+			return
+		}
+		// Previews count against the quota like any other component (updating one that is already active is fine)
+		err = h.driver.CheckActivationQuota(previewPath)
+		if err != nil {
+			log.Warning.Println("Not previewing", previewPath, "--", err)
+			return
+		}
+		err = h.driver.SetDeploymentIntention(previewPath, "active")
+		if err != nil {
+			log.Error.Println("Failed to activate preview", err)
+			return
+		}
+		log.Info.Println("Previewing", compID)
+		h.actionManager.UpdateComponentHash(compID)
+	case "closed":
+		intention, err := h.driver.FindDeploymentIntention(previewPath)
+		if err != nil {
+			log.Error.Println("Error finding deployment intention", err)
+			return
+		}
+		if intention == "" {
+			return
+		}
+
+		// The next pass deactivates it everywhere, since it is no longer active
+		err = h.driver.SetDeploymentIntention(previewPath, "not_a_component")
+		if err != nil {
+			log.Error.Println("Failed to deactivate preview", err)
+			return
+		}
+		log.Info.Println("Cleaning up preview", previewPath)
+		h.actionManager.ForgetComponent(previewPath)
 	}
 }
 
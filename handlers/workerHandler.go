@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/deployment"
+	"v9_deployment_manager/log"
+)
+
+const workersRoute = "/api/workers/"
+
+// Workers prove who they are by sending the secret they share with us in this header
+const workerSecretHeader = "X-V9-Worker-Secret"
+
+// Whether the request carries the secret workers share with us. Without a secret, no worker can register.
+func hasWorkerSecret(r *http.Request, secret string) bool {
+	sent := r.Header.Get(workerSecretHeader)
+	return secret != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(secret)) == 1
+}
+
+// Handles POST /api/workers/{name}/cordon, /drain, /uncordon and /heartbeat
+type WorkerHandler struct {
+	actionManager *deployment.ActionManager
+	workerSecret  string
+}
+
+func NewWorkerHandler(actionManager *deployment.ActionManager, workerSecret string) *WorkerHandler {
+	return &WorkerHandler{
+		actionManager: actionManager,
+		workerSecret:  workerSecret,
+	}
+}
+
+func (h *WorkerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse Path
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, workersRoute), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := parts[0], parts[1]
+	if action != "heartbeat" {
+		log.Info.Println(action, "worker", name)
+	}
+
+	// Tell the Action Manager
+	var err error
+	switch action {
+	case "cordon":
+		err = h.actionManager.CordonWorker(name)
+	case "drain":
+		err = h.actionManager.DrainWorker(name)
+	case "uncordon":
+		err = h.actionManager.UncordonWorker(name)
+	case "heartbeat":
+		if !hasWorkerSecret(r, h.workerSecret) {
+			http.Error(w, "missing or wrong worker secret", http.StatusForbidden)
+			return
+		}
+		err = h.actionManager.RecordHeartbeat(name)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Error.Println("Failed to", action, "worker", err)
+		if errors.Is(err, database.ErrUnknownWorker) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "could not "+action+" worker", http.StatusInternalServerError)
+		}
+		return
+	}
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
+
+type RegisterWorkerHandler struct {
+	actionManager *deployment.ActionManager
+	workerSecret  string
+}
+
+type RegisterWorkerBody struct {
+	Name   string            `json:"name"`
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels"`
+}
+
+func NewRegisterWorkerHandler(actionManager *deployment.ActionManager, workerSecret string) *RegisterWorkerHandler {
+	return &RegisterWorkerHandler{
+		actionManager: actionManager,
+		workerSecret:  workerSecret,
+	}
+}
+
+func (h *RegisterWorkerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Anyone who could register could take deploys meant for other workers
+	if !hasWorkerSecret(r, h.workerSecret) {
+		http.Error(w, "missing or wrong worker secret", http.StatusForbidden)
+		return
+	}
+	// Parse Body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error.Println("Error reading body", err)
+	}
+	log.Info.Println(string(body))
+	var p RegisterWorkerBody
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Error.Println("Failed to unmarshal body", err)
+		return
+	}
+	if p.Name == "" || p.URL == "" {
+		http.Error(w, "name and url are required", http.StatusBadRequest)
+		return
+	}
+	// Tell the Action Manager (which updates the database)
+	err = h.actionManager.RegisterWorker(p.Name, p.URL, p.Labels)
+	if err != nil {
+		log.Error.Println("Failed to register worker", err)
+		if errors.Is(err, database.ErrWorkerNameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, "could not register worker", http.StatusInternalServerError)
+		}
+		return
+	}
+	// Send Response
+
+	_, err = fmt.Fprintf(w, "10/4 Good Buddy")
+	if err != nil {
+		log.Error.Println("Failed to write back that everything worked", err)
+	}
+}
@@ -20,6 +20,19 @@ import (
 )
 
 const databasePollingInterval = time.Second * 3
+const defaultPlacementStrategy = deployment.RandomPlacement
+const defaultReconcileConcurrency = 4
+const defaultBlueGreenRetention = time.Minute * 10
+const defaultCanaryWindow = time.Minute * 10
+const defaultRollbackWatchWindow = time.Minute * 10
+const defaultReconcileInterval = time.Minute
+const defaultFailoverGracePeriod = time.Minute * 2
+const defaultWorkerHeartbeatTimeout = time.Second * 30
+const defaultDebounceWindow = time.Second * 15
+const defaultAutoscaleInterval = time.Second * 30
+const defaultScaleUpCooldown = time.Minute * 3
+const defaultScaleDownCooldown = time.Minute * 10
+const leaderElectionInterval = time.Second * 5
 
 func main() {
 	//Initialize default ports
@@ -32,15 +45,17 @@ func main() {
 		websitePort = ":3080"
 	}
 
+	// Check for dry run flag
+	dryRun := contains(os.Args, "--dry-run")
+	if dryRun {
+		log.Info.Println("Running in dry run mode, workers will not be touched")
+	}
+
 	// Seed the random number generator
 	rand.Seed(time.Now().Unix())
 
-	// Get workers from env
-	workers, envErr := getWorkers()
-	if envErr != nil {
-		log.Error.Println("Error getting worker info", envErr)
-		return
-	}
+	// Get static workers from env (if there are any)
+	staticWorkers := getWorkers()
 
 	// Get psql info from env
 	psqlInfo, psqlInfoErr := getPsqlInfo()
@@ -49,37 +64,229 @@ func main() {
 		return
 	}
 
-	log.Info.Println("CIPort", CIPort, "websitePort", websitePort, "workers", workers)
+	// Get the placement strategy from env (if there is one)
+	placer, placerErr := deployment.NewPlacer(getEnvVarOrDefault("V9_PLACEMENT_STRATEGY", defaultPlacementStrategy))
+	if placerErr != nil {
+		log.Error.Println("Error getting placement strategy", placerErr)
+		return
+	}
 
-	driver, dbErr := database.CreateDriver(psqlInfo)
-	if dbErr != nil {
-		log.Error.Println("Error connecting to DB", dbErr)
+	// Get the number of components we can reconcile at once from env (if it is set)
+	reconcileConcurrency, concurrencyErr := getIntEnvVarOrDefault("V9_RECONCILE_CONCURRENCY", defaultReconcileConcurrency)
+	if concurrencyErr != nil {
+		log.Error.Println("Error getting reconcile concurrency", concurrencyErr)
 		return
 	}
 
-	// We don't want old deploying entries
-	dbErr = driver.PurgeAllDeploymentEntries()
+	// Get how long to keep the old color of blue/green deployments from env (if it is set)
+	blueGreenRetention, retentionErr := getDurationEnvVarOrDefault("V9_BLUE_GREEN_RETENTION", defaultBlueGreenRetention)
+	if retentionErr != nil {
+		log.Error.Println("Error getting blue/green retention", retentionErr)
+		return
+	}
+
+	// Get how long to observe canaries from env (if it is set)
+	canaryWindow, canaryErr := getDurationEnvVarOrDefault("V9_CANARY_WINDOW", defaultCanaryWindow)
+	if canaryErr != nil {
+		log.Error.Println("Error getting canary window", canaryErr)
+		return
+	}
+
+	// Get how long to watch new hashes for regressions from env (if it is set)
+	rollbackWatchWindow, rollbackErr := getDurationEnvVarOrDefault("V9_ROLLBACK_WATCH_WINDOW", defaultRollbackWatchWindow)
+	if rollbackErr != nil {
+		log.Error.Println("Error getting rollback watch window", rollbackErr)
+		return
+	}
+
+	// Get how often to reconcile without being told to from env (if it is set)
+	reconcileInterval, intervalErr := getDurationEnvVarOrDefault("V9_RECONCILE_INTERVAL", defaultReconcileInterval)
+	if intervalErr == nil && reconcileInterval <= 0 {
+		intervalErr = fmt.Errorf("err: V9_RECONCILE_INTERVAL must be positive, was %s", reconcileInterval)
+	}
+	if intervalErr != nil {
+		log.Error.Println("Error getting reconcile interval", intervalErr)
+		return
+	}
+
+	// Get how long workers can be unreachable before their components are moved from env (if it is set)
+	failoverGracePeriod, failoverErr := getDurationEnvVarOrDefault("V9_FAILOVER_GRACE_PERIOD", defaultFailoverGracePeriod)
+	if failoverErr != nil {
+		log.Error.Println("Error getting failover grace period", failoverErr)
+		return
+	}
+
+	// Get how long registered workers can go without a heartbeat from env (if it is set)
+	heartbeatTimeout, heartbeatErr := getDurationEnvVarOrDefault("V9_WORKER_HEARTBEAT_TIMEOUT",
+		defaultWorkerHeartbeatTimeout)
+	if heartbeatErr != nil {
+		log.Error.Println("Error getting worker heartbeat timeout", heartbeatErr)
+		return
+	}
+
+	// Get the secret workers send to register and send heartbeats from env (without it, workers can't register)
+	workerSecret := getEnvVarOrDefault("V9_WORKER_SECRET", "")
+	if workerSecret == "" {
+		log.Warning.Println("V9_WORKER_SECRET is not set, so only the workers in V9_WORKERS can be used")
+	}
+
+	// Get how long to wait for more pushes before deploying the newest one from env (if it is set)
+	debounceWindow, debounceErr := getDurationEnvVarOrDefault("V9_DEBOUNCE_WINDOW", defaultDebounceWindow)
+	if debounceErr != nil {
+		log.Error.Println("Error getting debounce window", debounceErr)
+		return
+	}
+
+	// Get how often to autoscale components from env (if it is set)
+	autoscaleInterval, autoscaleErr := getDurationEnvVarOrDefault("V9_AUTOSCALE_INTERVAL", defaultAutoscaleInterval)
+	if autoscaleErr == nil && autoscaleInterval <= 0 {
+		autoscaleErr = fmt.Errorf("err: V9_AUTOSCALE_INTERVAL must be positive, was %s", autoscaleInterval)
+	}
+	if autoscaleErr != nil {
+		log.Error.Println("Error getting autoscale interval", autoscaleErr)
+		return
+	}
+
+	// Get how long to wait after scaling a component before scaling it up again from env (if it is set)
+	scaleUpCooldown, scaleUpErr := getDurationEnvVarOrDefault("V9_SCALE_UP_COOLDOWN", defaultScaleUpCooldown)
+	if scaleUpErr != nil {
+		log.Error.Println("Error getting scale up cooldown", scaleUpErr)
+		return
+	}
+
+	// Get how long to wait after scaling a component before scaling it down again from env (if it is set)
+	scaleDownCooldown, scaleDownErr := getDurationEnvVarOrDefault("V9_SCALE_DOWN_COOLDOWN", defaultScaleDownCooldown)
+	if scaleDownErr != nil {
+		log.Error.Println("Error getting scale down cooldown", scaleDownErr)
+		return
+	}
+
+	// Get where followers can forward requests to us from env, they can't forward anything without it
+	advertiseURL, advertiseErr := getEnvVar("V9_ADVERTISE_URL")
+	if advertiseErr == nil && advertiseURL == "" {
+		advertiseErr = errors.New("V9_ADVERTISE_URL must not be empty")
+	}
+	if advertiseErr != nil {
+		log.Error.Println("Error getting advertise URL", advertiseErr)
+		return
+	}
+
+	log.Info.Println("CIPort", CIPort, "websitePort", websitePort, "static workers", staticWorkers)
+
+	driver, dbErr := database.CreateDriver(psqlInfo)
 	if dbErr != nil {
-		log.Error.Println("DB error", dbErr)
+		log.Error.Println("Error connecting to DB", dbErr)
 		return
 	}
 
-	database.StartPollingPopulator(workers, databasePollingInterval, driver)
+	// Static workers never go away (and the ones that registered are loaded once we lead)
+	workers := database.NewWorkerRegistry(driver, heartbeatTimeout)
+	for _, w := range staticWorkers {
+		workers.AddStatic(w)
+	}
 
 	activator := activator.CreateActivator(driver)
-	actionManager := deployment.NewActionManager(activator, driver, workers)
-	// State may be dirty when we start
-	actionManager.NotifyComponentStateChanged()
+	actionManager := deployment.NewActionManager(activator, driver, workers, deployment.ActionManagerConfig{
+		Placer:               placer,
+		ReconcileConcurrency: reconcileConcurrency,
+		BlueGreenRetention:   blueGreenRetention,
+		CanaryWindow:         canaryWindow,
+		RollbackWatchWindow:  rollbackWatchWindow,
+		DryRun:               dryRun,
+		ReconcileInterval:    reconcileInterval,
+		FailoverGracePeriod:  failoverGracePeriod,
+		DebounceWindow:       debounceWindow,
+		AutoscaleInterval:    autoscaleInterval,
+		ScaleUpCooldown:      scaleUpCooldown,
+		ScaleDownCooldown:    scaleDownCooldown,
+	})
+
+	var handler http.Handler
+	if dryRun {
+		// Dry runs never lead, they only log what the leader would do
+		go planDryRun(workers, actionManager, reconcileInterval)
+		handler = handlers.NewDryRunHandler(http.DefaultServeMux)
+	} else {
+		// Only the leader touches the workers, everyone else forwards what they get to it
+		election := database.NewLeaderElection(driver, advertiseURL)
+		go func() {
+			election.Campaign(leaderElectionInterval)
+			lead(driver, workers, actionManager)
+			election.WatchLeadership(leaderElectionInterval, func(err error) {
+				// Someone else may be leading by now, so get out of their way
+				log.Error.Println("Lost leadership, exiting", err)
+				os.Exit(1)
+			})
+		}()
+		// Pushes aren't sent again, so they are saved for the next leader when there isn't one to forward them to
+		handler = handlers.NewLeaderForwarder(election, driver, http.DefaultServeMux, []string{"/payload"})
+	}
 
 	http.Handle("/payload", handlers.NewPushHandler(actionManager, driver))
 	http.Handle("/api/set_deployment_intention", handlers.NewDeploymentIntentionHandler(actionManager, driver))
+	http.Handle("/api/set_replicas", handlers.NewSetReplicasHandler(actionManager, driver))
+	http.Handle("/api/set_rollout_strategy", handlers.NewSetRolloutStrategyHandler(actionManager, driver))
+	http.Handle("/api/flip_blue_green", handlers.NewFlipBlueGreenHandler(actionManager))
+	http.Handle("/api/set_deployment_hash", handlers.NewSetDeploymentHashHandler(actionManager))
+	http.Handle("/api/unpin_deployment_hash", handlers.NewUnpinDeploymentHashHandler(actionManager))
+	http.Handle("/api/set_tracked_branch", handlers.NewSetTrackedBranchHandler(actionManager, driver))
+	http.Handle("/api/set_placement_constraints", handlers.NewSetPlacementConstraintsHandler(actionManager, driver))
+	http.Handle("/api/set_autoscaling", handlers.NewSetAutoscalingHandler(actionManager, driver))
+	http.Handle("/api/component_status", handlers.NewComponentStatusHandler(actionManager))
+	http.Handle("/api/deployments", handlers.NewDeploymentHistoryHandler(driver))
+	http.Handle("/api/scaling_decisions", handlers.NewScalingDecisionsHandler(driver))
+	http.Handle("/api/plan", handlers.NewPlanHandler(actionManager))
+	http.Handle("/api/metrics", handlers.NewMetricsHandler(actionManager))
+	http.Handle("/api/workers/", handlers.NewWorkerHandler(actionManager, workerSecret))
+	http.Handle("/api/register_worker", handlers.NewRegisterWorkerHandler(actionManager, workerSecret))
 	log.Info.Println("Starting Server...")
-	err := http.ListenAndServe(CIPort, nil)
+	err := http.ListenAndServe(CIPort, handler)
 	if err != nil {
 		log.Error.Println("CI http.ListenAndServe Error:", err)
 	}
 }
 
+// Start managing the workers, now that we are the leader
+func lead(driver *database.Driver, workers *database.WorkerRegistry, actionManager *deployment.ActionManager) {
+	// We don't want old deploying entries
+	dbErr := driver.PurgeAllDeploymentEntries()
+	if dbErr != nil {
+		log.Error.Println("DB error", dbErr)
+		os.Exit(1)
+	}
+
+	// Workers that registered with us (or with the leader before us) are still around
+	dbErr = workers.Load()
+	if dbErr != nil {
+		log.Error.Println("DB error", dbErr)
+		os.Exit(1)
+	}
+
+	database.StartPollingPopulator(workers, databasePollingInterval, driver)
+	dbErr = actionManager.Start()
+	if dbErr != nil {
+		log.Error.Println("DB error", dbErr)
+		os.Exit(1)
+	}
+}
+
+// Log what the leader would do every so often, going by what it saved
+func planDryRun(workers *database.WorkerRegistry, actionManager *deployment.ActionManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for now := time.Now(); ; now = <-ticker.C {
+		// Workers register (and send heartbeats) with the leader
+		err := workers.Load()
+		if err != nil {
+			log.Error.Println("DB error", err)
+			continue
+		}
+		err = actionManager.LogPlan(now)
+		if err != nil {
+			log.Error.Println("Could not plan:", err)
+		}
+	}
+}
+
 // Get env variables
 func getEnvVar(name string) (string, error) {
 	val, exists := os.LookupEnv(name)
@@ -90,19 +297,63 @@ func getEnvVar(name string) (string, error) {
 	return val, nil
 }
 
-func getWorkers() ([]*worker.V9Worker, error) {
-	workerString, err := getEnvVar("V9_WORKERS")
+func getEnvVarOrDefault(name string, defaultVal string) string {
+	val, exists := os.LookupEnv(name)
+	if !exists {
+		return defaultVal
+	}
+
+	return val
+}
+
+func getIntEnvVarOrDefault(name string, defaultVal int) (int, error) {
+	valString, exists := os.LookupEnv(name)
+	if !exists {
+		return defaultVal, nil
+	}
+
+	val, err := strconv.Atoi(valString)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("err: %s must be a valid integer, was %s: %w", name, valString, err)
+	}
+	if val < 1 {
+		return 0, fmt.Errorf("err: %s must be positive, was %d", name, val)
+	}
+
+	return val, nil
+}
+
+func getDurationEnvVarOrDefault(name string, defaultVal time.Duration) (time.Duration, error) {
+	valString, exists := os.LookupEnv(name)
+	if !exists {
+		return defaultVal, nil
+	}
+
+	val, err := time.ParseDuration(valString)
+	if err != nil {
+		return 0, fmt.Errorf("err: %s must be a valid duration, was %s: %w", name, valString, err)
+	}
+
+	return val, nil
+}
+
+func getWorkers() []*worker.V9Worker {
+	workerString := getEnvVarOrDefault("V9_WORKERS", "")
+	if workerString == "" {
+		return nil
 	}
 
 	workerUrls := strings.Split(workerString, ";")
 	var workers = make([]*worker.V9Worker, len(workerUrls))
 
 	for i, url := range workerUrls {
-		workers[i] = &worker.V9Worker{URL: url}
+		workers[i] = &worker.V9Worker{
+			// Static workers don't tell us their name, so they are named by their place in the list
+			Name: fmt.Sprintf("worker_%d", i),
+			URL:  url,
+		}
 	}
-	return workers, nil
+	return workers
 }
 
 func getPsqlInfo() (string, error) {
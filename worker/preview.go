@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Preview names end up in URLs, so the separator has to be something a URL can carry as is.
+// That means a repo that really is named like a preview (repo-pr-42) is taken for one.
+const previewSeparator = "-pr-"
+
+// The repo name of the preview component for a pull request, for example repo-pr-42
+func PreviewRepo(repo string, number int64) string {
+	return fmt.Sprintf("%s%s%d", repo, previewSeparator, number)
+}
+
+// The repo and pull request number a preview component was made from (ok is false if it is not a preview)
+func ParsePreviewRepo(previewRepo string) (repo string, number int64, ok bool) {
+	i := strings.LastIndex(previewRepo, previewSeparator)
+	if i < 0 {
+		return "", 0, false
+	}
+
+	number, err := strconv.ParseInt(previewRepo[i+len(previewSeparator):], 10, 64)
+	if err != nil || number < 1 {
+		return "", 0, false
+	}
+	return previewRepo[:i], number, true
+}
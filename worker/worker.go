@@ -3,13 +3,23 @@ package worker
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 	"v9_deployment_manager/log"
 )
 
+// Workers that hang must not hold up everything else, but activating can take a while (the worker loads the image)
+var (
+	queryClient  = &http.Client{Timeout: 10 * time.Second}
+	actionClient = &http.Client{Timeout: 5 * time.Minute}
+)
+
 type V9Worker struct {
-	URL string
+	Name   string
+	URL    string
+	Labels map[string]string
 }
 
 type ComponentPath struct {
@@ -27,10 +37,11 @@ type activateRequest struct {
 	ID              ComponentID `json:"id"`
 	ExecutableFile  string      `json:"executable_file"`
 	ExecutionMethod string      `json:"execution_method"`
+	Color           string      `json:"color,omitempty"`
 }
 
-func createActivateBody(compID ComponentID, tarPath string, executionMethod string) ([]byte, error) {
-	body, err := json.Marshal(activateRequest{compID, tarPath, executionMethod})
+func createActivateBody(compID ComponentID, tarPath string, executionMethod string, color string) ([]byte, error) {
+	body, err := json.Marshal(activateRequest{compID, tarPath, executionMethod, color})
 	return body, err
 }
 
@@ -44,6 +55,11 @@ func createDeactivateBody(compID ComponentID) ([]byte, error) {
 	return body, err
 }
 
+type liveColorRequest struct {
+	ID    ComponentPath `json:"id"`
+	Color string        `json:"color"`
+}
+
 type ComponentStats struct {
 	ID ComponentID `json:"id"`
 
@@ -116,7 +132,7 @@ type LogResponse struct {
 
 func (worker *V9Worker) post(route string, body []byte) (*http.Response, error) {
 	url := "http://" + worker.URL + route
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := actionClient.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		log.Error.Println("Failed to post", err)
 		return nil, err
@@ -127,7 +143,7 @@ func (worker *V9Worker) post(route string, body []byte) (*http.Response, error)
 
 func (worker *V9Worker) get(route string) (*http.Response, error) {
 	url := "http://" + worker.URL + route
-	resp, err := http.Get(url)
+	resp, err := queryClient.Get(url)
 	if err != nil {
 		log.Error.Println("Failed to get", err)
 		return nil, err
@@ -136,9 +152,10 @@ func (worker *V9Worker) get(route string) (*http.Response, error) {
 	return resp, nil
 }
 
-func (worker *V9Worker) Activate(component ComponentID, tarPath string) error {
+// Activate the component on the worker. The color is only needed for blue/green deployments, and can be left empty.
+func (worker *V9Worker) Activate(component ComponentID, tarPath string, color string) error {
 	// Marshal information into json body
-	body, err := createActivateBody(component, tarPath, "docker-archive")
+	body, err := createActivateBody(component, tarPath, "docker-archive", color)
 	if err != nil {
 		log.Error.Println("Failed to create activation body", err)
 		return err
@@ -188,6 +205,26 @@ func (worker *V9Worker) Deactivate(component ComponentID) error {
 	return nil
 }
 
+// Tell the worker which color of a blue/green component gets the traffic (the other one keeps running, idle)
+func (worker *V9Worker) SetLiveColor(compPath ComponentPath, color string) error {
+	body, err := json.Marshal(liveColorRequest{compPath, color})
+	if err != nil {
+		log.Error.Println("Failed to create live color body", err)
+		return err
+	}
+
+	resp, err := worker.post("/meta/set_live_color", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("worker %s answered %s when setting the live color", worker.Name, resp.Status)
+	}
+	return nil
+}
+
 // Deactivate component
 func DeactivateComponentEverywhere(compID ComponentID, workers []*V9Worker) {
 	for i := range workers {
@@ -200,23 +237,10 @@ func DeactivateComponentEverywhere(compID ComponentID, workers []*V9Worker) {
 }
 
 func (worker *V9Worker) Logs() (LogResponse, error) {
-	url := "http://" + worker.URL + "/meta/logs"
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Error.Println("Failed to get logs", err)
-		return LogResponse{}, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error.Println("Failure to read response from worker", err)
-		return LogResponse{}, err
-	}
-
 	var logResponse LogResponse
-	err = json.Unmarshal(respBody, &logResponse)
+	err := worker.getJSON("/meta/logs", &logResponse)
 	if err != nil {
+		log.Error.Println("Failed to get logs", err)
 		return LogResponse{}, err
 	}
 
@@ -224,24 +248,28 @@ func (worker *V9Worker) Logs() (LogResponse, error) {
 }
 
 func (worker *V9Worker) Status() (StatusResponse, error) {
-	resp, err := worker.get("/meta/status")
+	var statusResponse StatusResponse
+	err := worker.getJSON("/meta/status", &statusResponse)
 	if err != nil {
 		log.Error.Println("Failed to get status", err)
 		return StatusResponse{}, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+	return statusResponse, nil
+}
+
+// Get the route and read the JSON it answers with into v
+func (worker *V9Worker) getJSON(route string, v interface{}) error {
+	resp, err := worker.get(route)
 	if err != nil {
-		log.Error.Println("Failure to read status response from worker", err)
-		return StatusResponse{}, err
+		return err
 	}
+	defer resp.Body.Close()
 
-	var statusResponse StatusResponse
-	err = json.Unmarshal(respBody, &statusResponse)
+	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return StatusResponse{}, err
+		return err
 	}
 
-	return statusResponse, nil
+	return json.Unmarshal(respBody, v)
 }